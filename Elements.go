@@ -13,6 +13,24 @@ type Expression interface {
 	Compile(mm *MemoryManager) int
 	Derive(wrt string) Expression
 	Simplify() Expression
+	//Children returns the expressions this one is made of, in order. Changing the slice does not change the expression
+	Children() []Expression
+	//WithChildren returns a copy of this expression made of children instead. It panics if the count is wrong
+	WithChildren(children []Expression) Expression
+}
+
+//deriver is implemented by the expressions whose derivative is simplified by Derive
+type deriver interface {
+	derive(wrt string) Expression
+}
+
+//DeriveRaw takes the derivative of e with respect to wrt like e.Derive(wrt) but does not simplify it
+//SimplifyWithSteps(DeriveRaw(e, wrt)) shows the steps that turn it into e.Derive(wrt)
+func DeriveRaw(e Expression, wrt string) Expression {
+	if d, ok := e.(deriver); ok {
+		return d.derive(wrt)
+	}
+	return e.Derive(wrt)
 }
 
 //Siner takes the sine of its value
@@ -22,10 +40,14 @@ type Siner struct {
 
 //Derive takes the derivative of the sin(A) with respect to wrt
 func (s Siner) Derive(wrt string) Expression {
+	return s.derive(wrt).Simplify()
+}
 
+//derive is Derive without simplifying the result
+func (s Siner) derive(wrt string) Expression {
 	return Multiplier{
 		A: Coser{s.A},
-		B: s.A.Derive(wrt),
+		B: DeriveRaw(s.A, wrt),
 	}
 }
 
@@ -48,7 +70,7 @@ func (s Siner) Latex() string {
 func (s Siner) Compile(mm *MemoryManager) int {
 	aResult := s.A.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{SinBytecode, Bytecode(aResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: SinBytecode, A: aResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -59,6 +81,11 @@ type Coser struct {
 
 //Derive takes the derivative of the cos(A) with respect to wrt
 func (c Coser) Derive(wrt string) Expression {
+	return c.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (c Coser) derive(wrt string) Expression {
 	return Multiplier{
 		A: Multiplier{
 			A: Constant{
@@ -68,7 +95,7 @@ func (c Coser) Derive(wrt string) Expression {
 				A: c.A,
 			},
 		},
-		B: c.A.Derive(wrt),
+		B: DeriveRaw(c.A, wrt),
 	}
 }
 
@@ -91,7 +118,7 @@ func (c Coser) Latex() string {
 func (c Coser) Compile(mm *MemoryManager) int {
 	aResult := c.A.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{CosBytecode, Bytecode(aResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: CosBytecode, A: aResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -102,10 +129,15 @@ type Adder struct {
 
 //Derive takes the derivative of A + B.
 func (a Adder) Derive(wrt string) Expression {
+	return a.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (a Adder) derive(wrt string) Expression {
 	return Adder{
-		A: a.A.Derive(wrt),
-		B: a.B.Derive(wrt),
-	}.Simplify()
+		A: DeriveRaw(a.A, wrt),
+		B: DeriveRaw(a.B, wrt),
+	}
 }
 
 //Evaluate evaluates a+b
@@ -119,7 +151,7 @@ func (a Adder) Compile(mm *MemoryManager) int {
 	aResult := a.A.Compile(mm)
 	bResult := a.B.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{AddBytecode, Bytecode(aResult), Bytecode(bResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: AddBytecode, A: aResult, B: bResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -140,10 +172,15 @@ type Subtractor struct {
 
 //Derive takes the derivative of A - B.
 func (s Subtractor) Derive(wrt string) Expression {
+	return s.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (s Subtractor) derive(wrt string) Expression {
 	return Subtractor{
-		A: s.A.Derive(wrt),
-		B: s.B.Derive(wrt),
-	}.Simplify()
+		A: DeriveRaw(s.A, wrt),
+		B: DeriveRaw(s.B, wrt),
+	}
 }
 
 //Evaluate evaluates A-B
@@ -166,7 +203,7 @@ func (s Subtractor) Compile(mm *MemoryManager) int {
 	aResult := s.A.Compile(mm)
 	bResult := s.B.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{SubBytecode, Bytecode(aResult), Bytecode(bResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: SubBytecode, A: aResult, B: bResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -177,16 +214,21 @@ type Multiplier struct {
 
 //Derive takes the derivative of A * B.
 func (m Multiplier) Derive(wrt string) Expression {
+	return m.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (m Multiplier) derive(wrt string) Expression {
 	return Adder{
 		A: Multiplier{
 			A: m.A,
-			B: m.B.Derive(wrt),
+			B: DeriveRaw(m.B, wrt),
 		},
 		B: Multiplier{
-			A: m.A.Derive(wrt),
-			B: m.A,
+			A: DeriveRaw(m.A, wrt),
+			B: m.B,
 		},
-	}.Simplify()
+	}
 }
 
 //Evaluate evaluates A*B
@@ -209,7 +251,7 @@ func (m Multiplier) Compile(mm *MemoryManager) int {
 	aResult := m.A.Compile(mm)
 	bResult := m.B.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{MulBytecode, Bytecode(aResult), Bytecode(bResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: MulBytecode, A: aResult, B: bResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -220,22 +262,27 @@ type Divider struct {
 
 //Derive takes the derivative of A / B.
 func (d Divider) Derive(wrt string) Expression {
+	return d.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (d Divider) derive(wrt string) Expression {
 	return Divider{
 		A: Subtractor{
 			A: Multiplier{
 				A: d.B,
-				B: d.A.Derive(wrt),
+				B: DeriveRaw(d.A, wrt),
 			},
 			B: Multiplier{
 				A: d.A,
-				B: d.B.Derive(wrt),
+				B: DeriveRaw(d.B, wrt),
 			},
 		},
 		B: Powerer{
 			Base:     d.B,
 			Exponent: Constant{2},
 		},
-	}.Simplify()
+	}
 }
 
 //Evaluate evaluates A/B
@@ -258,7 +305,7 @@ func (d Divider) Compile(mm *MemoryManager) int {
 	aResult := d.A.Compile(mm)
 	bResult := d.B.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{DivBytecode, Bytecode(aResult), Bytecode(bResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: DivBytecode, A: aResult, B: bResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -274,20 +321,25 @@ func (n NaturalLogger) Evaluate(vars map[string]float64) float64 {
 
 //Derive takes the derivative of ln(A).
 func (n NaturalLogger) Derive(wrt string) Expression {
+	return n.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (n NaturalLogger) derive(wrt string) Expression {
 	return Multiplier{
 		A: Divider{
 			A: Constant{1},
 			B: n.A,
 		},
-		B: n.A.Derive(wrt),
-	}.Simplify()
+		B: DeriveRaw(n.A, wrt),
+	}
 }
 
 //Compile compiles ln(A) to bytecode
 func (n NaturalLogger) Compile(mm *MemoryManager) int {
 	aResult := n.A.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{LNBytecode, Bytecode(aResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: LNBytecode, A: aResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -309,6 +361,11 @@ type Powerer struct {
 //Derive takes the derivative of A^B
 //https://www.youtube.com/watch?v=SUxcFxM65Ho
 func (p Powerer) Derive(wrt string) Expression {
+	return p.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (p Powerer) derive(wrt string) Expression {
 	return Multiplier{
 		A: Powerer{
 			Base:     p.Base,
@@ -318,18 +375,18 @@ func (p Powerer) Derive(wrt string) Expression {
 			A: Divider{
 				A: Multiplier{
 					A: p.Exponent,
-					B: p.Base.Derive(wrt),
+					B: DeriveRaw(p.Base, wrt),
 				},
 				B: p.Base,
 			},
 			B: Multiplier{
-				A: p.Exponent.Derive(wrt),
+				A: DeriveRaw(p.Exponent, wrt),
 				B: NaturalLogger{
 					A: p.Base,
 				},
 			},
 		},
-	}.Simplify()
+	}
 }
 
 //Evaluate evaluates base^power
@@ -339,12 +396,38 @@ func (p Powerer) Evaluate(vars map[string]float64) float64 {
 
 //String returns a string representation of base^exponent
 func (p Powerer) String() string {
-	return "(" + p.Base.String() + " ^ " + p.Exponent.String() + ")"
+	base := p.Base.String()
+	if isNegative(p.Base) {
+		//-x ^ 2 would be read back as -(x ^ 2)
+		base = "(" + base + ")"
+	}
+	return "(" + base + " ^ " + p.Exponent.String() + ")"
 }
 
 //Latex returns a latex representation of base^exponent
 func (p Powerer) Latex() string {
-	return p.Base.Latex() + "^{" + p.Exponent.Latex() + "}"
+	base := p.Base.Latex()
+	switch p.Base.(type) {
+	case Adder, Subtractor, Multiplier, Divider, Powerer:
+		//Without brackets the power would only apply to the last part of the base
+		base = "\\left(" + base + "\\right)"
+	default:
+		if isNegative(p.Base) {
+			base = "\\left(" + base + "\\right)"
+		}
+	}
+	return base + "^{" + p.Exponent.Latex() + "}"
+}
+
+//isNegative reports whether e is printed starting with a minus sign that is not in brackets
+func isNegative(e Expression) bool {
+	switch v := e.(type) {
+	case Negator:
+		return true
+	case Constant:
+		return v.Value < 0 || math.Signbit(v.Value)
+	}
+	return false
 }
 
 //Compile compiles it to bytecode
@@ -352,7 +435,7 @@ func (p Powerer) Compile(mm *MemoryManager) int {
 	aResult := p.Base.Compile(mm)
 	bResult := p.Exponent.Compile(mm)
 	myResultIndex := mm.GetResultSpace()
-	mm.AddBytecode([]Bytecode{PowBytecode, Bytecode(aResult), Bytecode(bResult), Bytecode(myResultIndex)})
+	mm.AddInstruction(Instruction{Op: PowBytecode, A: aResult, B: bResult, Dst: myResultIndex})
 	return myResultIndex
 }
 
@@ -367,12 +450,25 @@ func (c Constant) Derive(wrt string) Expression {
 }
 
 //String returns the string representation of the number
+//Infinities are written as the constant inf and NaN as 0/0 so they can be parsed again
 func (c Constant) String() string {
+	if math.IsInf(c.Value, 1) {
+		return "inf"
+	} else if math.IsInf(c.Value, -1) {
+		return "-inf"
+	} else if math.IsNaN(c.Value) {
+		return "(0 / 0)"
+	}
 	return fmt.Sprintf("%g", c.Value)
 }
 
 //Latex returns the latex representation of the number
 func (c Constant) Latex() string {
+	if math.IsInf(c.Value, 1) {
+		return constantLatex["inf"]
+	} else if math.IsInf(c.Value, -1) {
+		return "-" + constantLatex["inf"]
+	}
 	return fmt.Sprintf("%g", c.Value)
 }
 
@@ -387,6 +483,71 @@ func (c Constant) Compile(mm *MemoryManager) int {
 	return i
 }
 
+//builtinConstants are the names read as constants unless changed with WithConstants or WithoutConstants
+//A constant is never a variable, so a value given for e to Evaluate is ignored and the derivative with respect to e is 0
+//unless the expression was parsed with WithoutConstants("e")
+var builtinConstants = map[string]float64{
+	"pi":  math.Pi,
+	"e":   math.E,
+	"tau": 2 * math.Pi,
+	"phi": math.Phi,
+	"inf": math.Inf(1),
+}
+
+//constantLatex is how named constants are written in latex when it is not just their name
+var constantLatex = map[string]string{
+	"pi":  "\\pi",
+	"tau": "\\tau",
+	"phi": "\\phi",
+	"inf": "\\infty",
+}
+
+//NamedConstant holds a constant with a name such as pi
+type NamedConstant struct {
+	Name  string
+	Value float64
+}
+
+//Derive takes the derivative of a constant (0)
+func (n NamedConstant) Derive(wrt string) Expression {
+	return Constant{0}
+}
+
+//String returns the name of the constant
+func (n NamedConstant) String() string {
+	return n.Name
+}
+
+//Latex returns the latex symbol for the constant
+func (n NamedConstant) Latex() string {
+	if l, ok := constantLatex[n.Name]; ok {
+		return l
+	}
+	return n.Name
+}
+
+//Evaluate returns the value of the constant
+func (n NamedConstant) Evaluate(vars map[string]float64) float64 {
+	return n.Value
+}
+
+//Compile creates a place to store the constant
+func (n NamedConstant) Compile(mm *MemoryManager) int {
+	i := mm.AddConstant(n.Value)
+	return i
+}
+
+//constantValue returns the value of e if it is a Constant or NamedConstant
+func constantValue(e Expression) (float64, bool) {
+	switch v := e.(type) {
+	case Constant:
+		return v.Value, true
+	case NamedConstant:
+		return v.Value, true
+	}
+	return 0, false
+}
+
 //Variable holds a variable in an equation
 type Variable struct {
 	Symbol string
@@ -422,3 +583,48 @@ func (v Variable) Derive(wrt string) Expression {
 	return Constant{0}
 
 }
+
+//Negator negates its value
+type Negator struct {
+	A Expression
+}
+
+//Derive takes the derivative of -A
+func (n Negator) Derive(wrt string) Expression {
+	return n.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (n Negator) derive(wrt string) Expression {
+	return Negator{
+		A: DeriveRaw(n.A, wrt),
+	}
+}
+
+//Evaluate evaluates -A
+func (n Negator) Evaluate(vars map[string]float64) float64 {
+	return -n.A.Evaluate(vars)
+}
+
+//String returns a string representation of -A
+func (n Negator) String() string {
+	return "-" + n.A.String()
+}
+
+//Latex returns a latex representation of -A
+func (n Negator) Latex() string {
+	switch n.A.(type) {
+	case Adder, Subtractor:
+		//Sums are not parenthesized in latex so the sign would only apply to the first term
+		return "-\\left(" + n.A.Latex() + "\\right)"
+	}
+	return "-" + n.A.Latex()
+}
+
+//Compile compiles -A to bytecode
+func (n Negator) Compile(mm *MemoryManager) int {
+	aResult := n.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: NegBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
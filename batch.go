@@ -0,0 +1,134 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// batchRows is how many rows EvalBatch runs each instruction over at a time
+const batchRows = 256
+
+// batchMemory is the registers of a program for a chunk of rows
+type batchMemory struct {
+	//regs[r] is register r for every row of the chunk
+	regs [][]float64
+	args []float64
+}
+
+// EvalBatch evaluates the program for every row of columns, which holds the values of each variable, and saves the results to out
+// Every variable must have a column as long as out. It is safe to call from several goroutines at once
+func (p *Program) EvalBatch(columns map[string][]float64, out []float64) error {
+	cols := make([][]float64, len(p.varNames))
+	var missing []string
+	for i, name := range p.varNames {
+		col, ok := columns[name]
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		if len(col) != len(out) {
+			return fmt.Errorf("column %s has %d rows but out has %d", name, len(col), len(out))
+		}
+		cols[i] = col
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return &UnboundVariableError{Symbols: missing}
+	}
+
+	mem := p.getBatchMemory()
+	for start := 0; start < len(out); start += batchRows {
+		end := start + batchRows
+		if end > len(out) {
+			end = len(out)
+		}
+		//Variables are read straight from their columns as they are never written
+		for i, r := range p.varRegisters {
+			mem.regs[r] = cols[i][start:end]
+		}
+		p.runBatch(mem, end-start)
+		copy(out[start:end], mem.regs[p.result][:end-start])
+	}
+	for _, r := range p.varRegisters {
+		mem.regs[r] = nil
+	}
+	p.batchScratch.Put(mem)
+	return nil
+}
+
+// getBatchMemory returns registers for a chunk of rows, which must be given back to p.batchScratch afterwards
+// Constants are filled in for every row and never written
+func (p *Program) getBatchMemory() *batchMemory {
+	if mem, ok := p.batchScratch.Get().(*batchMemory); ok {
+		return mem
+	}
+	mem := &batchMemory{
+		regs: make([][]float64, len(p.memory)),
+		args: make([]float64, p.maxCallArgs()),
+	}
+	buf := make([]float64, len(p.memory)*batchRows)
+	for r := range mem.regs {
+		mem.regs[r] = buf[r*batchRows : (r+1)*batchRows]
+		for i := range mem.regs[r] {
+			mem.regs[r][i] = p.memory[r]
+		}
+	}
+	return mem
+}
+
+// runBatch runs each instruction over the first n rows of the registers before moving to the next
+func (p *Program) runBatch(mem *batchMemory, n int) {
+	regs := mem.regs
+	for _, ins := range p.code {
+		dst := regs[ins.Dst][:n]
+		if ins.Op == CallBytecode {
+			c := p.calls[ins.A]
+			args := mem.args[:len(c.args)]
+			for i := range dst {
+				for j, r := range c.args {
+					args[j] = regs[r][i]
+				}
+				dst[i] = c.fn.Eval(args)
+			}
+			continue
+		}
+		a := regs[ins.A][:n]
+		switch ins.Op {
+		case AddBytecode:
+			b := regs[ins.B][:n]
+			for i := range dst {
+				dst[i] = a[i] + b[i]
+			}
+		case SubBytecode:
+			b := regs[ins.B][:n]
+			for i := range dst {
+				dst[i] = a[i] - b[i]
+			}
+		case MulBytecode:
+			b := regs[ins.B][:n]
+			for i := range dst {
+				dst[i] = a[i] * b[i]
+			}
+		case DivBytecode:
+			b := regs[ins.B][:n]
+			for i := range dst {
+				dst[i] = a[i] / b[i]
+			}
+		case PowBytecode:
+			b := regs[ins.B][:n]
+			for i := range dst {
+				dst[i] = math.Pow(a[i], b[i])
+			}
+		case NegBytecode:
+			for i := range dst {
+				dst[i] = -a[i]
+			}
+		default:
+			f := unaryFunctions[ins.Op]
+			for i := range dst {
+				dst[i] = f(a[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// CompileExpressionStruct compiles e to a function that reads the values of its variables from the fields of a struct
+// sample is a pointer to a struct of the type the function will be given
+// A variable is read from the field with the tag `expr:"name"` or, if no field has that tag, the field called name.
+// A field tagged `expr:"-"` is never read. The fields must be float64 or float32
+// It returns an error if a variable has no field. The function panics if it is given anything but a pointer to that type of struct
+func CompileExpressionStruct(e Expression, sample interface{}, opts ...CompileOption) (func(s interface{}) float64, error) {
+	p, err := CompileProgram(e, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return p.BindStruct(sample)
+}
+
+// BindStruct returns a function that runs the program with the variables read from the fields of a struct
+// It binds the fields like CompileExpressionStruct
+func (p *Program) BindStruct(sample interface{}) (func(s interface{}) float64, error) {
+	ptrType := reflect.TypeOf(sample)
+	if ptrType == nil || ptrType.Kind() != reflect.Ptr || ptrType.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("can only bind to a pointer to a struct, not %v", ptrType)
+	}
+	fields, err := structFields(ptrType.Elem(), p.varNames)
+	if err != nil {
+		return nil, err
+	}
+	return func(s interface{}) float64 {
+		v := reflect.ValueOf(s)
+		if v.Type() != ptrType {
+			panic(fmt.Errorf("expression is bound to %v but was given %v", ptrType, v.Type()))
+		}
+		v = v.Elem()
+		mem := p.getScratch()
+		for i, r := range p.varRegisters {
+			(*mem)[r] = v.Field(fields[i]).Float()
+		}
+		return p.finish(mem)
+	}, nil
+}
+
+// structFields finds the index of the field of t that each variable is read from
+func structFields(t reflect.Type, names []string) ([]int, error) {
+	tagged := map[string]int{}
+	named := map[string]int{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("expr")
+		if tag == "-" {
+			continue
+		}
+		if tag != "" {
+			tagged[tag] = i
+		} else {
+			named[f.Name] = i
+		}
+	}
+	fields := make([]int, len(names))
+	for i, name := range names {
+		index, ok := tagged[name]
+		if !ok {
+			index, ok = named[name]
+		}
+		if !ok {
+			return nil, fmt.Errorf("%v has no field for variable %s", t, name)
+		}
+		switch t.Field(index).Type.Kind() {
+		case reflect.Float64, reflect.Float32:
+		default:
+			return nil, fmt.Errorf("field %s of %v for variable %s is %v, not a float", t.Field(index).Name, t, name, t.Field(index).Type)
+		}
+		fields[i] = index
+	}
+	return fields, nil
+}
@@ -0,0 +1,315 @@
+package parser
+
+import "fmt"
+
+//Walk calls visit for e and then for each of its children in order, depth first
+//If visit returns false the children of that expression are skipped
+func Walk(e Expression, visit func(Expression) bool) {
+	if !visit(e) {
+		return
+	}
+	for _, child := range e.Children() {
+		Walk(child, visit)
+	}
+}
+
+//Rewrite rebuilds e from the bottom up, replacing each expression with the result of calling rewrite on it
+//rewrite is given each expression after its children have already been rewritten
+func Rewrite(e Expression, rewrite func(Expression) Expression) Expression {
+	children := e.Children()
+	if len(children) > 0 {
+		newChildren := make([]Expression, len(children))
+		for i := range children {
+			newChildren[i] = Rewrite(children[i], rewrite)
+		}
+		e = e.WithChildren(newChildren)
+	}
+	return rewrite(e)
+}
+
+//checkChildCount panics if an expression that takes n children is given a different number
+func checkChildCount(e Expression, children []Expression, n int) {
+	if len(children) != n {
+		panic(fmt.Sprintf("%T takes %d children but was given %d", e, n, len(children)))
+	}
+}
+
+//Children returns A and B
+func (a Adder) Children() []Expression {
+	return []Expression{a.A, a.B}
+}
+
+//WithChildren returns A+B with A and B replaced by children
+func (a Adder) WithChildren(children []Expression) Expression {
+	checkChildCount(a, children, 2)
+	return Adder{A: children[0], B: children[1]}
+}
+
+//Children returns A and B
+func (s Subtractor) Children() []Expression {
+	return []Expression{s.A, s.B}
+}
+
+//WithChildren returns A-B with A and B replaced by children
+func (s Subtractor) WithChildren(children []Expression) Expression {
+	checkChildCount(s, children, 2)
+	return Subtractor{A: children[0], B: children[1]}
+}
+
+//Children returns A and B
+func (m Multiplier) Children() []Expression {
+	return []Expression{m.A, m.B}
+}
+
+//WithChildren returns A*B with A and B replaced by children
+func (m Multiplier) WithChildren(children []Expression) Expression {
+	checkChildCount(m, children, 2)
+	return Multiplier{A: children[0], B: children[1]}
+}
+
+//Children returns A and B
+func (d Divider) Children() []Expression {
+	return []Expression{d.A, d.B}
+}
+
+//WithChildren returns A/B with A and B replaced by children
+func (d Divider) WithChildren(children []Expression) Expression {
+	checkChildCount(d, children, 2)
+	return Divider{A: children[0], B: children[1]}
+}
+
+//Children returns Base and Exponent
+func (p Powerer) Children() []Expression {
+	return []Expression{p.Base, p.Exponent}
+}
+
+//WithChildren returns Base^Exponent with Base and Exponent replaced by children
+func (p Powerer) WithChildren(children []Expression) Expression {
+	checkChildCount(p, children, 2)
+	return Powerer{Base: children[0], Exponent: children[1]}
+}
+
+//Children returns A
+func (s Siner) Children() []Expression {
+	return []Expression{s.A}
+}
+
+//WithChildren returns sin(A) with A replaced by the only child
+func (s Siner) WithChildren(children []Expression) Expression {
+	checkChildCount(s, children, 1)
+	return Siner{children[0]}
+}
+
+//Children returns A
+func (c Coser) Children() []Expression {
+	return []Expression{c.A}
+}
+
+//WithChildren returns cos(A) with A replaced by the only child
+func (c Coser) WithChildren(children []Expression) Expression {
+	checkChildCount(c, children, 1)
+	return Coser{children[0]}
+}
+
+//Children returns A
+func (n NaturalLogger) Children() []Expression {
+	return []Expression{n.A}
+}
+
+//WithChildren returns ln(A) with A replaced by the only child
+func (n NaturalLogger) WithChildren(children []Expression) Expression {
+	checkChildCount(n, children, 1)
+	return NaturalLogger{children[0]}
+}
+
+//Children returns A
+func (n Negator) Children() []Expression {
+	return []Expression{n.A}
+}
+
+//WithChildren returns -A with A replaced by the only child
+func (n Negator) WithChildren(children []Expression) Expression {
+	checkChildCount(n, children, 1)
+	return Negator{children[0]}
+}
+
+//Children returns A
+func (t Tanner) Children() []Expression {
+	return []Expression{t.A}
+}
+
+//WithChildren returns tan(A) with A replaced by the only child
+func (t Tanner) WithChildren(children []Expression) Expression {
+	checkChildCount(t, children, 1)
+	return Tanner{children[0]}
+}
+
+//Children returns A
+func (e Exponentiator) Children() []Expression {
+	return []Expression{e.A}
+}
+
+//WithChildren returns exp(A) with A replaced by the only child
+func (e Exponentiator) WithChildren(children []Expression) Expression {
+	checkChildCount(e, children, 1)
+	return Exponentiator{children[0]}
+}
+
+//Children returns A
+func (s SquareRooter) Children() []Expression {
+	return []Expression{s.A}
+}
+
+//WithChildren returns sqrt(A) with A replaced by the only child
+func (s SquareRooter) WithChildren(children []Expression) Expression {
+	checkChildCount(s, children, 1)
+	return SquareRooter{children[0]}
+}
+
+//Children returns A
+func (a AbsoluteValuer) Children() []Expression {
+	return []Expression{a.A}
+}
+
+//WithChildren returns abs(A) with A replaced by the only child
+func (a AbsoluteValuer) WithChildren(children []Expression) Expression {
+	checkChildCount(a, children, 1)
+	return AbsoluteValuer{children[0]}
+}
+
+//Children returns A
+func (c CommonLogger) Children() []Expression {
+	return []Expression{c.A}
+}
+
+//WithChildren returns log10(A) with A replaced by the only child
+func (c CommonLogger) WithChildren(children []Expression) Expression {
+	checkChildCount(c, children, 1)
+	return CommonLogger{children[0]}
+}
+
+//Children returns A
+func (b BinaryLogger) Children() []Expression {
+	return []Expression{b.A}
+}
+
+//WithChildren returns log2(A) with A replaced by the only child
+func (b BinaryLogger) WithChildren(children []Expression) Expression {
+	checkChildCount(b, children, 1)
+	return BinaryLogger{children[0]}
+}
+
+//Children returns A
+func (a ArcSiner) Children() []Expression {
+	return []Expression{a.A}
+}
+
+//WithChildren returns asin(A) with A replaced by the only child
+func (a ArcSiner) WithChildren(children []Expression) Expression {
+	checkChildCount(a, children, 1)
+	return ArcSiner{children[0]}
+}
+
+//Children returns A
+func (a ArcCoser) Children() []Expression {
+	return []Expression{a.A}
+}
+
+//WithChildren returns acos(A) with A replaced by the only child
+func (a ArcCoser) WithChildren(children []Expression) Expression {
+	checkChildCount(a, children, 1)
+	return ArcCoser{children[0]}
+}
+
+//Children returns A
+func (a ArcTanner) Children() []Expression {
+	return []Expression{a.A}
+}
+
+//WithChildren returns atan(A) with A replaced by the only child
+func (a ArcTanner) WithChildren(children []Expression) Expression {
+	checkChildCount(a, children, 1)
+	return ArcTanner{children[0]}
+}
+
+//Children returns A
+func (h HyperbolicSiner) Children() []Expression {
+	return []Expression{h.A}
+}
+
+//WithChildren returns sinh(A) with A replaced by the only child
+func (h HyperbolicSiner) WithChildren(children []Expression) Expression {
+	checkChildCount(h, children, 1)
+	return HyperbolicSiner{children[0]}
+}
+
+//Children returns A
+func (h HyperbolicCoser) Children() []Expression {
+	return []Expression{h.A}
+}
+
+//WithChildren returns cosh(A) with A replaced by the only child
+func (h HyperbolicCoser) WithChildren(children []Expression) Expression {
+	checkChildCount(h, children, 1)
+	return HyperbolicCoser{children[0]}
+}
+
+//Children returns A
+func (h HyperbolicTanner) Children() []Expression {
+	return []Expression{h.A}
+}
+
+//WithChildren returns tanh(A) with A replaced by the only child
+func (h HyperbolicTanner) WithChildren(children []Expression) Expression {
+	checkChildCount(h, children, 1)
+	return HyperbolicTanner{children[0]}
+}
+
+//Children returns nothing since a constant has no children
+func (c Constant) Children() []Expression {
+	return nil
+}
+
+//WithChildren returns a constant as it is since it has no children
+func (c Constant) WithChildren(children []Expression) Expression {
+	checkChildCount(c, children, 0)
+	return c
+}
+
+//Children returns nothing since a named constant has no children
+func (n NamedConstant) Children() []Expression {
+	return nil
+}
+
+//WithChildren returns a named constant as it is since it has no children
+func (n NamedConstant) WithChildren(children []Expression) Expression {
+	checkChildCount(n, children, 0)
+	return n
+}
+
+//Children returns nothing since a variable has no children
+func (v Variable) Children() []Expression {
+	return nil
+}
+
+//WithChildren returns a variable as it is since it has no children
+func (v Variable) WithChildren(children []Expression) Expression {
+	checkChildCount(v, children, 0)
+	return v
+}
+
+//Children returns a copy of the arguments
+func (f FunctionCall) Children() []Expression {
+	return append([]Expression(nil), f.Args...)
+}
+
+//WithChildren returns a call to the same function with children as its arguments
+func (f FunctionCall) WithChildren(children []Expression) Expression {
+	checkChildCount(f, children, len(f.Args))
+	args := make([]Expression, len(children))
+	copy(args, children)
+	return FunctionCall{
+		Func: f.Func,
+		Args: args,
+	}
+}
@@ -0,0 +1,186 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package parser
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+//Machine code lives in pages that are never writable and executable at once
+//Small functions are packed into a shared page while it is still writable
+//The page is made read+exec the first time any function in it runs or when it is full
+//and from then on nothing more is written to it until every function in it is released
+
+//codeAlign keeps every function in a page starting on a 16 byte boundary
+const codeAlign = 16
+
+//maxFreePages is how many empty pages are kept for reuse instead of being unmapped
+const maxFreePages = 4
+
+var pageSize = syscall.Getpagesize()
+
+//codePage is mapped memory holding the code of one or more functions
+type codePage struct {
+	mem []byte
+	//used is how many bytes have been written to
+	used int
+	//live is how many functions in the page have not been released
+	live int
+	//sealed is 1 when the page is read+exec and 0 when it is read+write
+	sealed uint32
+}
+
+//codeBlock is the code of one function in a page
+type codeBlock struct {
+	page  *codePage
+	start uintptr
+}
+
+//codeCache hands out the memory code is put in
+type codeCache struct {
+	mu sync.Mutex
+	//open is the page small functions are being written to. It is never sealed
+	open *codePage
+	//free are empty read+write pages
+	free []*codePage
+	//pages is how many pages are mapped
+	pages int
+}
+
+var jitCache = &codeCache{}
+
+//alloc writes code into a page and returns where it is
+//The code can not run until the block has been entered
+func (c *codeCache) alloc(code []byte) (*codeBlock, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	size := (len(code) + codeAlign - 1) / codeAlign * codeAlign
+	if size > pageSize {
+		//Too big to share so give it pages of its own
+		page, err := c.mapPage((size + pageSize - 1) / pageSize * pageSize)
+		if err != nil {
+			return nil, err
+		}
+		copy(page.mem, code)
+		page.used = size
+		page.live = 1
+		if err := c.sealLocked(page); err != nil {
+			c.unmapLocked(page)
+			return nil, err
+		}
+		return &codeBlock{page: page, start: uintptr(unsafe.Pointer(&page.mem[0]))}, nil
+	}
+	if c.open != nil && c.open.used+size > len(c.open.mem) {
+		//Full so nothing else will be written to it
+		if err := c.sealLocked(c.open); err != nil {
+			return nil, err
+		}
+	}
+	if c.open == nil {
+		if n := len(c.free); n > 0 {
+			c.open = c.free[n-1]
+			c.free = c.free[:n-1]
+		} else {
+			page, err := c.mapPage(pageSize)
+			if err != nil {
+				return nil, err
+			}
+			c.open = page
+		}
+	}
+	page := c.open
+	copy(page.mem[page.used:], code)
+	block := &codeBlock{page: page, start: uintptr(unsafe.Pointer(&page.mem[page.used]))}
+	page.used += size
+	page.live++
+	return block, nil
+}
+
+//enter makes sure the block can be run
+func (b *codeBlock) enter() {
+	if atomic.LoadUint32(&b.page.sealed) == 0 {
+		jitCache.seal(b.page)
+	}
+}
+
+//release gives the memory of the block back. It must not be run afterwards
+func (b *codeBlock) release() {
+	jitCache.release(b.page)
+}
+
+func (c *codeCache) seal(page *codePage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.sealLocked(page); err != nil {
+		//The code can not be run without this
+		panic(err)
+	}
+}
+
+//sealLocked makes page read+exec. c.mu must be held
+func (c *codeCache) sealLocked(page *codePage) error {
+	if page.sealed == 1 {
+		return nil
+	}
+	if err := syscall.Mprotect(page.mem, syscall.PROT_READ|syscall.PROT_EXEC); err != nil {
+		return fmt.Errorf("jit: mprotect: %v", err)
+	}
+	atomic.StoreUint32(&page.sealed, 1)
+	if c.open == page {
+		c.open = nil
+	}
+	return nil
+}
+
+func (c *codeCache) release(page *codePage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	page.live--
+	if page.live > 0 {
+		return
+	}
+	if page == c.open {
+		//Nothing in it has run so it can be written over
+		page.used = 0
+		return
+	}
+	if len(page.mem) == pageSize && len(c.free) < maxFreePages {
+		if err := syscall.Mprotect(page.mem, syscall.PROT_READ|syscall.PROT_WRITE); err == nil {
+			atomic.StoreUint32(&page.sealed, 0)
+			page.used = 0
+			c.free = append(c.free, page)
+			return
+		}
+	}
+	c.unmapLocked(page)
+}
+
+//mapPage maps size bytes of read+write memory. c.mu must be held
+func (c *codeCache) mapPage(size int) (*codePage, error) {
+	mem, err := syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_PRIVATE|syscall.MAP_ANON)
+	if err != nil {
+		return nil, fmt.Errorf("jit: mmap: %v", err)
+	}
+	c.pages++
+	return &codePage{mem: mem}, nil
+}
+
+//unmapLocked unmaps page. c.mu must be held
+func (c *codeCache) unmapLocked(page *codePage) {
+	//Munmap only fails for memory that is not mapped
+	syscall.Munmap(page.mem)
+	page.mem = nil
+	c.pages--
+}
+
+//mappedPages returns how many pages are mapped and how many of those are free
+func (c *codeCache) mappedPages() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.pages, len(c.free)
+}
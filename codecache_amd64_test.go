@@ -0,0 +1,60 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package parser
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCodeCache(t *testing.T) {
+	pagesBefore, _ := jitCache.mappedPages()
+	e, err := ParseExpression("x*2+1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Functions that are compiled before any of them run share pages
+	funcs := make([]*JitFunction, 100)
+	for i := range funcs {
+		funcs[i], err = NewJitFunction(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	pages, _ := jitCache.mappedPages()
+	if pages-pagesBefore > 2 {
+		t.Errorf("100 small functions took %d pages", pages-pagesBefore)
+	}
+	for i := range funcs {
+		if res := funcs[i].Evaluate(map[string]float64{"x": float64(i)}); res != float64(i)*2+1 {
+			t.Errorf("Function %d gave %g, wanted %g", i, res, float64(i)*2+1)
+		}
+	}
+	for i := range funcs {
+		funcs[i].Close()
+	}
+	pages, free := jitCache.mappedPages()
+	if pages-free > pagesBefore {
+		t.Errorf("%d pages still in use after closing every function, %d before", pages-free, pagesBefore)
+	}
+
+	//Code longer than a page gets pages of its own
+	long, err := ParseExpression("x" + strings.Repeat("+x", 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewJitFunction(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := f.Evaluate(map[string]float64{"x": 2}); res != 1002 {
+		t.Errorf("Long function gave %g, wanted 1002", res)
+	}
+	f.Close()
+	f.Close()
+	pagesAfter, free := jitCache.mappedPages()
+	if pagesAfter-free > pagesBefore {
+		t.Errorf("%d pages still in use after closing the long function, %d before", pagesAfter-free, pagesBefore)
+	}
+}
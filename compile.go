@@ -1,31 +1,113 @@
 package parser
 
 import (
+	"fmt"
 	"math"
 )
 
-// Bytecode is an instruction type for the interpreter
+// Bytecode is the operation of an instruction for the interpreter
 type Bytecode int
 
 // List of Bytecodes
 const (
-	AddBytecode = iota //Add two memory locations and save to third memory location
-	SubBytecode        //Subtract two memory locations and save to third memory location
+	AddBytecode = iota //Add registers A and B and save to register Dst
+	SubBytecode        //Subtract register B from register A and save to register Dst
 	MulBytecode
 	DivBytecode
-	PowBytecode //Raise first location to power of second location and save it at third
-	CosBytecode //Take the cosine of the first locartion and save it to the second
+	PowBytecode //Raise register A to the power of register B and save it to register Dst
+	CosBytecode //Take the cosine of register A and save it to register Dst
 	SinBytecode
 	LNBytecode
+	NegBytecode //Negate register A and save it to register Dst
+	//Call the function call at index A of the program's calls and save the result to register Dst
+	CallBytecode
+	//Elementary functions of register A saved to register Dst
+	TanBytecode
+	ExpBytecode
+	SqrtBytecode
+	AbsBytecode
+	Log10Bytecode
+	Log2Bytecode
+	AsinBytecode
+	AcosBytecode
+	AtanBytecode
+	SinhBytecode
+	CoshBytecode
+	TanhBytecode
 )
 
+var bytecodeNames = [...]string{
+	AddBytecode:   "add",
+	SubBytecode:   "sub",
+	MulBytecode:   "mul",
+	DivBytecode:   "div",
+	PowBytecode:   "pow",
+	CosBytecode:   "cos",
+	SinBytecode:   "sin",
+	LNBytecode:    "ln",
+	NegBytecode:   "neg",
+	CallBytecode:  "call",
+	TanBytecode:   "tan",
+	ExpBytecode:   "exp",
+	SqrtBytecode:  "sqrt",
+	AbsBytecode:   "abs",
+	Log10Bytecode: "log10",
+	Log2Bytecode:  "log2",
+	AsinBytecode:  "asin",
+	AcosBytecode:  "acos",
+	AtanBytecode:  "atan",
+	SinhBytecode:  "sinh",
+	CoshBytecode:  "cosh",
+	TanhBytecode:  "tanh",
+}
+
+// String returns the name of the operation as it is disassembled
+func (op Bytecode) String() string {
+	if !op.valid() {
+		return "unknown"
+	}
+	return bytecodeNames[op]
+}
+
+func (op Bytecode) valid() bool {
+	return op >= 0 && int(op) < len(bytecodeNames)
+}
+
+// operands returns how many registers the operation reads from A and B
+func (op Bytecode) operands() int {
+	switch op {
+	case AddBytecode, SubBytecode, MulBytecode, DivBytecode, PowBytecode:
+		return 2
+	case CallBytecode:
+		return 0
+	}
+	return 1
+}
+
+// Instruction is one step of a compiled expression
+// It reads registers A and B and saves the result to register Dst
+// Operations of one value leave B as 0 and CallBytecode has the index of its call in A
+type Instruction struct {
+	Op   Bytecode
+	A, B int
+	Dst  int
+}
+
+// call is a function called by CallBytecode and the registers of its arguments
+type call struct {
+	fn   *Function
+	args []int
+}
+
 // MemoryManager keeps track of constants, variables and working memory
 // Also holds place for instructions
 type MemoryManager struct {
-	bc        []Bytecode
+	code      []Instruction
 	constants []float64
 	//Guide for which places to fill with which variables
 	varLocations map[string]int
+	//Functions called by CallBytecode
+	calls []call
 }
 
 // NewMemoryManager returns a new default memory manager
@@ -36,9 +118,9 @@ func NewMemoryManager() MemoryManager {
 	}
 }
 
-// AddBytecode adds a bytecode to the instructions
-func (mm *MemoryManager) AddBytecode(bc []Bytecode) {
-	mm.bc = append(mm.bc, bc...)
+// AddInstruction adds an instruction to the code
+func (mm *MemoryManager) AddInstruction(ins Instruction) {
+	mm.code = append(mm.code, ins)
 }
 
 // AddVariable adds a variable and tracks it to be set at execution time
@@ -56,6 +138,12 @@ func (mm *MemoryManager) AddVariable(name string) int {
 
 }
 
+// AddCall adds a call of f with the arguments in the registers args and returns the index to call it by
+func (mm *MemoryManager) AddCall(f *Function, args []int) int {
+	mm.calls = append(mm.calls, call{fn: f, args: append([]int(nil), args...)})
+	return len(mm.calls) - 1
+}
+
 // AddConstant adds a constant into the memory
 func (mm *MemoryManager) AddConstant(v float64) int {
 	//Add Constant to memory and return the index to it
@@ -73,92 +161,134 @@ func (mm *MemoryManager) GetResultSpace() int {
 }
 
 // CompileExpression takes an expression and turns it into bytecode
-func CompileExpression(e Expression) func(vs map[string]float64) float64 {
-	mm := NewMemoryManager()
-	lastResIndex := e.Compile(&mm)
-	vars := make([]string, len(mm.varLocations))
-	i := 0
-	for k := range mm.varLocations {
-		vars[i] = k
-		i++
+// The function can be called from several goroutines at once
+// It panics if the expression compiles to a malformed program, CompileProgram returns the error instead
+func CompileExpression(e Expression, opts ...CompileOption) func(vs map[string]float64) float64 {
+	p, err := CompileProgram(e, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return p.Evaluate
+}
+
+// Evaluate runs the program with the values of the variables in vs
+// It is safe to call from several goroutines at once
+func (p *Program) Evaluate(vs map[string]float64) float64 {
+	mem := p.getScratch()
+	//save vs to mem bank
+	for i, k := range p.varNames {
+		(*mem)[p.varRegisters[i]] = vs[k]
 	}
+	return p.finish(mem)
+}
 
-	compiledFunc := func(vs map[string]float64) float64 {
-		//Copy over code and constants
-		code := mm.bc
-		consts := mm.constants
-		//save vs to mem bank
-		for _, k := range vars {
-			index := mm.varLocations[k]
-			val := vs[k]
-			consts[index] = val
+// EvaluateArgs runs the program with args as the values of the variables in the order of Variables
+// It panics if there are not as many args as variables
+func (p *Program) EvaluateArgs(args []float64) float64 {
+	p.checkArgs(args)
+	mem := p.getScratch()
+	for i, r := range p.varRegisters {
+		(*mem)[r] = args[i]
+	}
+	return p.finish(mem)
+}
 
-		}
-		//Execute code
-		for i := 0; i < len(code); {
-			ins := code[i]
-			switch ins {
-			case AddBytecode:
-				Ai := code[i+1]
-				Bi := code[i+2]
-
-				Ri := code[i+3]
-				consts[Ri] = consts[Ai] + consts[Bi]
-				i += 4
-			case SubBytecode:
-				Ai := code[i+1]
-				Bi := code[i+2]
-
-				Ri := code[i+3]
-				consts[Ri] = consts[Ai] - consts[Bi]
-				i += 4
-			case MulBytecode:
-				Ai := code[i+1]
-				Bi := code[i+2]
-
-				Ri := code[i+3]
-				consts[Ri] = consts[Ai] * consts[Bi]
-				i += 4
-
-			case DivBytecode:
-				Ai := code[i+1]
-				Bi := code[i+2]
-
-				Ri := code[i+3]
-				consts[Ri] = consts[Ai] / consts[Bi]
-				i += 4
-			case PowBytecode:
-				Ai := code[i+1]
-				Bi := code[i+2]
-
-				Ri := code[i+3]
-				consts[Ri] = math.Pow(consts[Ai], consts[Bi])
-				i += 4
-			case CosBytecode:
-				Ai := code[i+1]
-
-				Ri := code[i+2]
-				consts[Ri] = math.Cos(consts[Ai])
-				i += 3
-
-			case SinBytecode:
-				Ai := code[i+1]
-				Ri := code[i+2]
-				consts[Ri] = math.Sin(consts[Ai])
-				i += 3
-			case LNBytecode:
-				Ai := code[i+1]
-				Ri := code[i+2]
-				consts[Ri] = math.Log(consts[Ai])
-				i += 3
-
-			default:
-				//This should really never happen but just go to next instruction
-				i++
+// finish runs the program on mem once the variables are set and gives mem back
+func (p *Program) finish(mem *[]float64) float64 {
+	p.run(*mem)
+	res := (*mem)[p.result]
+	p.scratch.Put(mem)
+	return res
+}
+
+// Variables returns the variables of the program in the order EvaluateArgs takes them, which is sorted
+func (p *Program) Variables() []string {
+	return append([]string(nil), p.varNames...)
+}
+
+func (p *Program) checkArgs(args []float64) {
+	if len(args) != len(p.varNames) {
+		panic(fmt.Errorf("expression takes %d variables %v but was given %d", len(p.varNames), p.varNames, len(args)))
+	}
+}
+
+// CompileExpressionArgs compiles e like CompileExpression but the function takes the values of the variables
+// as a slice in the order of the returned names instead of a map
+func CompileExpressionArgs(e Expression, opts ...CompileOption) ([]string, func(args []float64) float64) {
+	p, err := CompileProgram(e, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return p.Variables(), p.EvaluateArgs
+}
+
+// run executes the code on scratch memory from getScratch
+func (p *Program) run(mem []float64) {
+	//The arguments of calls go after the registers
+	args := mem[len(p.memory):]
+	for _, ins := range p.code {
+		switch ins.Op {
+		case AddBytecode:
+			mem[ins.Dst] = mem[ins.A] + mem[ins.B]
+		case SubBytecode:
+			mem[ins.Dst] = mem[ins.A] - mem[ins.B]
+		case MulBytecode:
+			mem[ins.Dst] = mem[ins.A] * mem[ins.B]
+		case DivBytecode:
+			mem[ins.Dst] = mem[ins.A] / mem[ins.B]
+		case PowBytecode:
+			mem[ins.Dst] = math.Pow(mem[ins.A], mem[ins.B])
+		case CosBytecode:
+			mem[ins.Dst] = math.Cos(mem[ins.A])
+		case SinBytecode:
+			mem[ins.Dst] = math.Sin(mem[ins.A])
+		case LNBytecode:
+			mem[ins.Dst] = math.Log(mem[ins.A])
+		case NegBytecode:
+			mem[ins.Dst] = -mem[ins.A]
+		case TanBytecode:
+			mem[ins.Dst] = math.Tan(mem[ins.A])
+		case ExpBytecode:
+			mem[ins.Dst] = math.Exp(mem[ins.A])
+		case SqrtBytecode:
+			mem[ins.Dst] = math.Sqrt(mem[ins.A])
+		case AbsBytecode:
+			mem[ins.Dst] = math.Abs(mem[ins.A])
+		case Log10Bytecode:
+			mem[ins.Dst] = math.Log10(mem[ins.A])
+		case Log2Bytecode:
+			mem[ins.Dst] = math.Log2(mem[ins.A])
+		case AsinBytecode:
+			mem[ins.Dst] = math.Asin(mem[ins.A])
+		case AcosBytecode:
+			mem[ins.Dst] = math.Acos(mem[ins.A])
+		case AtanBytecode:
+			mem[ins.Dst] = math.Atan(mem[ins.A])
+		case SinhBytecode:
+			mem[ins.Dst] = math.Sinh(mem[ins.A])
+		case CoshBytecode:
+			mem[ins.Dst] = math.Cosh(mem[ins.A])
+		case TanhBytecode:
+			mem[ins.Dst] = math.Tanh(mem[ins.A])
+		case CallBytecode:
+			c := p.calls[ins.A]
+			for j, r := range c.args {
+				args[j] = mem[r]
 			}
+			mem[ins.Dst] = c.fn.Eval(args[:len(c.args)])
+		}
+	}
+}
 
+// CompileExpressionStrict compiles e like CompileExpression but the function returns an UnboundVariableError
+// instead of using 0 for missing variables
+func CompileExpressionStrict(e Expression, opts ...CompileOption) func(vs map[string]float64) (float64, error) {
+	f := CompileExpression(e, opts...)
+	names := Variables(e)
+	return func(vs map[string]float64) (float64, error) {
+		if err := checkBound(names, vs); err != nil {
+			return 0, err
 		}
-		return consts[lastResIndex]
+		return f(vs), nil
 	}
-	return compiledFunc
 }
@@ -0,0 +1,534 @@
+package parser
+
+import "math"
+
+//Tanner takes the tangent of its value
+type Tanner struct {
+	A Expression
+}
+
+//Derive takes the derivative of tan(A) which is sec^2(A) * A'
+func (t Tanner) Derive(wrt string) Expression {
+	return t.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (t Tanner) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(t.A, wrt),
+		B: Powerer{
+			Base:     Coser{t.A},
+			Exponent: Constant{2},
+		},
+	}
+}
+
+//Evaluate evaluates tan(A)
+func (t Tanner) Evaluate(vars map[string]float64) float64 {
+	return math.Tan(t.A.Evaluate(vars))
+}
+
+//String returns a string representation of tan(A)
+func (t Tanner) String() string {
+	return "tan(" + t.A.String() + ")"
+}
+
+//Latex returns a latex representation of tan(A)
+func (t Tanner) Latex() string {
+	return "\\tan\\left(" + t.A.Latex() + "\\right)"
+}
+
+//Compile compiles tan(A) to bytecode
+func (t Tanner) Compile(mm *MemoryManager) int {
+	aResult := t.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: TanBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//Exponentiator raises e to the power of its value
+type Exponentiator struct {
+	A Expression
+}
+
+//Derive takes the derivative of exp(A) which is exp(A) * A'
+func (e Exponentiator) Derive(wrt string) Expression {
+	return e.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (e Exponentiator) derive(wrt string) Expression {
+	return Multiplier{
+		A: Exponentiator{e.A},
+		B: DeriveRaw(e.A, wrt),
+	}
+}
+
+//Evaluate evaluates exp(A)
+func (e Exponentiator) Evaluate(vars map[string]float64) float64 {
+	return math.Exp(e.A.Evaluate(vars))
+}
+
+//String returns a string representation of exp(A)
+func (e Exponentiator) String() string {
+	return "exp(" + e.A.String() + ")"
+}
+
+//Latex returns a latex representation of exp(A)
+func (e Exponentiator) Latex() string {
+	return "e^{" + e.A.Latex() + "}"
+}
+
+//Compile compiles exp(A) to bytecode
+func (e Exponentiator) Compile(mm *MemoryManager) int {
+	aResult := e.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: ExpBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//SquareRooter takes the square root of its value
+type SquareRooter struct {
+	A Expression
+}
+
+//Derive takes the derivative of sqrt(A) which is A' / (2 * sqrt(A))
+func (s SquareRooter) Derive(wrt string) Expression {
+	return s.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (s SquareRooter) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(s.A, wrt),
+		B: Multiplier{
+			A: Constant{2},
+			B: SquareRooter{s.A},
+		},
+	}
+}
+
+//Evaluate evaluates sqrt(A)
+func (s SquareRooter) Evaluate(vars map[string]float64) float64 {
+	return math.Sqrt(s.A.Evaluate(vars))
+}
+
+//String returns a string representation of sqrt(A)
+func (s SquareRooter) String() string {
+	return "sqrt(" + s.A.String() + ")"
+}
+
+//Latex returns a latex representation of sqrt(A)
+func (s SquareRooter) Latex() string {
+	return "\\sqrt{" + s.A.Latex() + "}"
+}
+
+//Compile compiles sqrt(A) to bytecode
+func (s SquareRooter) Compile(mm *MemoryManager) int {
+	aResult := s.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: SqrtBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//AbsoluteValuer takes the absolute value of its value
+type AbsoluteValuer struct {
+	A Expression
+}
+
+//Derive takes the derivative of abs(A) which is A / |A| * A'
+func (a AbsoluteValuer) Derive(wrt string) Expression {
+	return a.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (a AbsoluteValuer) derive(wrt string) Expression {
+	return Multiplier{
+		A: Divider{
+			A: a.A,
+			B: AbsoluteValuer{a.A},
+		},
+		B: DeriveRaw(a.A, wrt),
+	}
+}
+
+//Evaluate evaluates abs(A)
+func (a AbsoluteValuer) Evaluate(vars map[string]float64) float64 {
+	return math.Abs(a.A.Evaluate(vars))
+}
+
+//String returns a string representation of abs(A)
+func (a AbsoluteValuer) String() string {
+	return "abs(" + a.A.String() + ")"
+}
+
+//Latex returns a latex representation of abs(A)
+func (a AbsoluteValuer) Latex() string {
+	return "\\left|" + a.A.Latex() + "\\right|"
+}
+
+//Compile compiles abs(A) to bytecode
+func (a AbsoluteValuer) Compile(mm *MemoryManager) int {
+	aResult := a.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: AbsBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//CommonLogger takes the base 10 log of its value
+type CommonLogger struct {
+	A Expression
+}
+
+//Derive takes the derivative of log10(A) which is A' / (A * ln(10))
+func (c CommonLogger) Derive(wrt string) Expression {
+	return c.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (c CommonLogger) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(c.A, wrt),
+		B: Multiplier{
+			A: c.A,
+			B: Constant{math.Ln10},
+		},
+	}
+}
+
+//Evaluate evaluates log10(A)
+func (c CommonLogger) Evaluate(vars map[string]float64) float64 {
+	return math.Log10(c.A.Evaluate(vars))
+}
+
+//String returns a string representation of log10(A)
+func (c CommonLogger) String() string {
+	return "log10(" + c.A.String() + ")"
+}
+
+//Latex returns a latex representation of log10(A)
+func (c CommonLogger) Latex() string {
+	return "\\log_{10}\\left(" + c.A.Latex() + "\\right)"
+}
+
+//Compile compiles log10(A) to bytecode
+func (c CommonLogger) Compile(mm *MemoryManager) int {
+	aResult := c.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: Log10Bytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//BinaryLogger takes the base 2 log of its value
+type BinaryLogger struct {
+	A Expression
+}
+
+//Derive takes the derivative of log2(A) which is A' / (A * ln(2))
+func (b BinaryLogger) Derive(wrt string) Expression {
+	return b.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (b BinaryLogger) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(b.A, wrt),
+		B: Multiplier{
+			A: b.A,
+			B: Constant{math.Ln2},
+		},
+	}
+}
+
+//Evaluate evaluates log2(A)
+func (b BinaryLogger) Evaluate(vars map[string]float64) float64 {
+	return math.Log2(b.A.Evaluate(vars))
+}
+
+//String returns a string representation of log2(A)
+func (b BinaryLogger) String() string {
+	return "log2(" + b.A.String() + ")"
+}
+
+//Latex returns a latex representation of log2(A)
+func (b BinaryLogger) Latex() string {
+	return "\\log_{2}\\left(" + b.A.Latex() + "\\right)"
+}
+
+//Compile compiles log2(A) to bytecode
+func (b BinaryLogger) Compile(mm *MemoryManager) int {
+	aResult := b.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: Log2Bytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//ArcSiner takes the inverse sine of its value
+type ArcSiner struct {
+	A Expression
+}
+
+//Derive takes the derivative of asin(A) which is A' / sqrt(1 - A^2)
+func (a ArcSiner) Derive(wrt string) Expression {
+	return a.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (a ArcSiner) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(a.A, wrt),
+		B: SquareRooter{Subtractor{
+			A: Constant{1},
+			B: Powerer{
+				Base:     a.A,
+				Exponent: Constant{2},
+			},
+		}},
+	}
+}
+
+//Evaluate evaluates asin(A)
+func (a ArcSiner) Evaluate(vars map[string]float64) float64 {
+	return math.Asin(a.A.Evaluate(vars))
+}
+
+//String returns a string representation of asin(A)
+func (a ArcSiner) String() string {
+	return "asin(" + a.A.String() + ")"
+}
+
+//Latex returns a latex representation of asin(A)
+func (a ArcSiner) Latex() string {
+	return "\\arcsin\\left(" + a.A.Latex() + "\\right)"
+}
+
+//Compile compiles asin(A) to bytecode
+func (a ArcSiner) Compile(mm *MemoryManager) int {
+	aResult := a.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: AsinBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//ArcCoser takes the inverse cosine of its value
+type ArcCoser struct {
+	A Expression
+}
+
+//Derive takes the derivative of acos(A) which is -A' / sqrt(1 - A^2)
+func (a ArcCoser) Derive(wrt string) Expression {
+	return a.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (a ArcCoser) derive(wrt string) Expression {
+	return Negator{Divider{
+		A: DeriveRaw(a.A, wrt),
+		B: SquareRooter{Subtractor{
+			A: Constant{1},
+			B: Powerer{
+				Base:     a.A,
+				Exponent: Constant{2},
+			},
+		}},
+	}}
+}
+
+//Evaluate evaluates acos(A)
+func (a ArcCoser) Evaluate(vars map[string]float64) float64 {
+	return math.Acos(a.A.Evaluate(vars))
+}
+
+//String returns a string representation of acos(A)
+func (a ArcCoser) String() string {
+	return "acos(" + a.A.String() + ")"
+}
+
+//Latex returns a latex representation of acos(A)
+func (a ArcCoser) Latex() string {
+	return "\\arccos\\left(" + a.A.Latex() + "\\right)"
+}
+
+//Compile compiles acos(A) to bytecode
+func (a ArcCoser) Compile(mm *MemoryManager) int {
+	aResult := a.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: AcosBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//ArcTanner takes the inverse tangent of its value
+type ArcTanner struct {
+	A Expression
+}
+
+//Derive takes the derivative of atan(A) which is A' / (1 + A^2)
+func (a ArcTanner) Derive(wrt string) Expression {
+	return a.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (a ArcTanner) derive(wrt string) Expression {
+	return Divider{
+		A: DeriveRaw(a.A, wrt),
+		B: Adder{
+			A: Constant{1},
+			B: Powerer{
+				Base:     a.A,
+				Exponent: Constant{2},
+			},
+		},
+	}
+}
+
+//Evaluate evaluates atan(A)
+func (a ArcTanner) Evaluate(vars map[string]float64) float64 {
+	return math.Atan(a.A.Evaluate(vars))
+}
+
+//String returns a string representation of atan(A)
+func (a ArcTanner) String() string {
+	return "atan(" + a.A.String() + ")"
+}
+
+//Latex returns a latex representation of atan(A)
+func (a ArcTanner) Latex() string {
+	return "\\arctan\\left(" + a.A.Latex() + "\\right)"
+}
+
+//Compile compiles atan(A) to bytecode
+func (a ArcTanner) Compile(mm *MemoryManager) int {
+	aResult := a.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: AtanBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//HyperbolicSiner takes the hyperbolic sine of its value
+type HyperbolicSiner struct {
+	A Expression
+}
+
+//Derive takes the derivative of sinh(A) which is cosh(A) * A'
+func (h HyperbolicSiner) Derive(wrt string) Expression {
+	return h.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (h HyperbolicSiner) derive(wrt string) Expression {
+	return Multiplier{
+		A: HyperbolicCoser{h.A},
+		B: DeriveRaw(h.A, wrt),
+	}
+}
+
+//Evaluate evaluates sinh(A)
+func (h HyperbolicSiner) Evaluate(vars map[string]float64) float64 {
+	return math.Sinh(h.A.Evaluate(vars))
+}
+
+//String returns a string representation of sinh(A)
+func (h HyperbolicSiner) String() string {
+	return "sinh(" + h.A.String() + ")"
+}
+
+//Latex returns a latex representation of sinh(A)
+func (h HyperbolicSiner) Latex() string {
+	return "\\sinh\\left(" + h.A.Latex() + "\\right)"
+}
+
+//Compile compiles sinh(A) to bytecode
+func (h HyperbolicSiner) Compile(mm *MemoryManager) int {
+	aResult := h.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: SinhBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//HyperbolicCoser takes the hyperbolic cosine of its value
+type HyperbolicCoser struct {
+	A Expression
+}
+
+//Derive takes the derivative of cosh(A) which is sinh(A) * A'
+func (h HyperbolicCoser) Derive(wrt string) Expression {
+	return h.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (h HyperbolicCoser) derive(wrt string) Expression {
+	return Multiplier{
+		A: HyperbolicSiner{h.A},
+		B: DeriveRaw(h.A, wrt),
+	}
+}
+
+//Evaluate evaluates cosh(A)
+func (h HyperbolicCoser) Evaluate(vars map[string]float64) float64 {
+	return math.Cosh(h.A.Evaluate(vars))
+}
+
+//String returns a string representation of cosh(A)
+func (h HyperbolicCoser) String() string {
+	return "cosh(" + h.A.String() + ")"
+}
+
+//Latex returns a latex representation of cosh(A)
+func (h HyperbolicCoser) Latex() string {
+	return "\\cosh\\left(" + h.A.Latex() + "\\right)"
+}
+
+//Compile compiles cosh(A) to bytecode
+func (h HyperbolicCoser) Compile(mm *MemoryManager) int {
+	aResult := h.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: CoshBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
+
+//HyperbolicTanner takes the hyperbolic tangent of its value
+type HyperbolicTanner struct {
+	A Expression
+}
+
+//Derive takes the derivative of tanh(A) which is (1 - tanh(A)^2) * A'
+func (h HyperbolicTanner) Derive(wrt string) Expression {
+	return h.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (h HyperbolicTanner) derive(wrt string) Expression {
+	return Multiplier{
+		A: Subtractor{
+			A: Constant{1},
+			B: Powerer{
+				Base:     HyperbolicTanner{h.A},
+				Exponent: Constant{2},
+			},
+		},
+		B: DeriveRaw(h.A, wrt),
+	}
+}
+
+//Evaluate evaluates tanh(A)
+func (h HyperbolicTanner) Evaluate(vars map[string]float64) float64 {
+	return math.Tanh(h.A.Evaluate(vars))
+}
+
+//String returns a string representation of tanh(A)
+func (h HyperbolicTanner) String() string {
+	return "tanh(" + h.A.String() + ")"
+}
+
+//Latex returns a latex representation of tanh(A)
+func (h HyperbolicTanner) Latex() string {
+	return "\\tanh\\left(" + h.A.Latex() + "\\right)"
+}
+
+//Compile compiles tanh(A) to bytecode
+func (h HyperbolicTanner) Compile(mm *MemoryManager) int {
+	aResult := h.A.Compile(mm)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: TanhBytecode, A: aResult, Dst: myResultIndex})
+	return myResultIndex
+}
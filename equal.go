@@ -0,0 +1,250 @@
+package parser
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+	"math"
+	"reflect"
+	"sort"
+)
+
+//Equal reports whether a and b are the same expression, node for node
+//Constants are equal when their values are, with NaN equal to NaN so that every expression is equal to itself
+func Equal(a, b Expression) bool {
+	if !sameNode(a, b) {
+		return false
+	}
+	ac := a.Children()
+	bc := b.Children()
+	if len(ac) != len(bc) {
+		return false
+	}
+	for i := range ac {
+		if !Equal(ac[i], bc[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//EqualCommutative reports whether a and b are the same expression if the terms of sums and
+//the factors of products can be in any order, so a+b+c is equal to c+(b+a)
+func EqualCommutative(a, b Expression) bool {
+	if !sameNode(a, b) {
+		return false
+	}
+	var ac, bc []Expression
+	if isCommutative(a) {
+		ac = flattenCommutative(a, nil)
+		bc = flattenCommutative(b, nil)
+	} else {
+		ac = a.Children()
+		bc = b.Children()
+	}
+	if len(ac) != len(bc) {
+		return false
+	}
+	if !isCommutative(a) {
+		for i := range ac {
+			if !EqualCommutative(ac[i], bc[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	//Match every term in a with a different term in b
+	used := make([]bool, len(bc))
+	for i := range ac {
+		found := false
+		for j := range bc {
+			if !used[j] && EqualCommutative(ac[i], bc[j]) {
+				used[j] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+//Hash returns a hash of e that is the same for expressions that are Equal
+//It does not change between runs of the program
+func Hash(e Expression) uint64 {
+	h := fnv.New64a()
+	writeNode(h, e)
+	for _, child := range e.Children() {
+		writeUint64(h, Hash(child))
+	}
+	return h.Sum64()
+}
+
+//HashCommutative returns a hash of e that is the same for expressions that are EqualCommutative
+func HashCommutative(e Expression) uint64 {
+	h := fnv.New64a()
+	writeNode(h, e)
+	if !isCommutative(e) {
+		for _, child := range e.Children() {
+			writeUint64(h, HashCommutative(child))
+		}
+		return h.Sum64()
+	}
+	//The order of the terms should not matter so hash them in sorted order
+	terms := flattenCommutative(e, nil)
+	hashes := make([]uint64, len(terms))
+	for i := range terms {
+		hashes[i] = HashCommutative(terms[i])
+	}
+	sort.Slice(hashes, func(i, j int) bool { return hashes[i] < hashes[j] })
+	for _, th := range hashes {
+		writeUint64(h, th)
+	}
+	return h.Sum64()
+}
+
+//sameNode reports whether a and b are the same type of node holding the same data, not looking at their children
+func sameNode(a, b Expression) bool {
+	if reflect.TypeOf(a) != reflect.TypeOf(b) {
+		return false
+	}
+	switch av := a.(type) {
+	case Constant:
+		return sameFloat(av.Value, b.(Constant).Value)
+	case NamedConstant:
+		bv := b.(NamedConstant)
+		return av.Name == bv.Name && sameFloat(av.Value, bv.Value)
+	case Variable:
+		return av.Symbol == b.(Variable).Symbol
+	case FunctionCall:
+		return av.Func == b.(FunctionCall).Func
+	}
+	return true
+}
+
+func sameFloat(a, b float64) bool {
+	return a == b || (math.IsNaN(a) && math.IsNaN(b))
+}
+
+//isCommutative reports whether the order of the terms of e does not matter
+func isCommutative(e Expression) bool {
+	switch e.(type) {
+	case Adder, Multiplier:
+		return true
+	}
+	return false
+}
+
+//flattenCommutative appends the terms of a chain of the same commutative operation to terms
+//For (a+b)+(c+d) that is a, b, c and d
+func flattenCommutative(e Expression, terms []Expression) []Expression {
+	for _, child := range e.Children() {
+		if reflect.TypeOf(child) == reflect.TypeOf(e) {
+			terms = flattenCommutative(child, terms)
+		} else {
+			terms = append(terms, child)
+		}
+	}
+	return terms
+}
+
+//writeNode writes the type of e and the data it holds that is not a child
+func writeNode(h interface{ Write([]byte) (int, error) }, e Expression) {
+	h.Write([]byte(reflect.TypeOf(e).String()))
+	switch v := e.(type) {
+	case Constant:
+		writeFloat(h, v.Value)
+	case NamedConstant:
+		h.Write([]byte(v.Name))
+		writeFloat(h, v.Value)
+	case Variable:
+		h.Write([]byte(v.Symbol))
+	case FunctionCall:
+		h.Write([]byte(v.Func.Name))
+	}
+}
+
+//writeFloat writes f such that values that sameFloat considers equal are written the same
+func writeFloat(h interface{ Write([]byte) (int, error) }, f float64) {
+	if f == 0 {
+		//-0 == 0
+		f = 0
+	}
+	if math.IsNaN(f) {
+		f = math.NaN()
+	}
+	writeUint64(h, math.Float64bits(f))
+}
+
+func writeUint64(h interface{ Write([]byte) (int, error) }, v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	h.Write(buf[:])
+}
+
+//expressionIndex numbers expressions so that Equal expressions get the same number
+type expressionIndex struct {
+	expressions []Expression
+	byHash      map[uint64][]int
+	//commutative is set if expressions that are EqualCommutative get the same number
+	commutative bool
+}
+
+func newExpressionIndex() *expressionIndex {
+	return &expressionIndex{
+		byHash: map[uint64][]int{},
+	}
+}
+
+//newCommutativeExpressionIndex returns an index that gives EqualCommutative expressions the same number, so a*b is b*a
+func newCommutativeExpressionIndex() *expressionIndex {
+	x := newExpressionIndex()
+	x.commutative = true
+	return x
+}
+
+func (x *expressionIndex) hash(e Expression) uint64 {
+	if x.commutative {
+		return HashCommutative(e)
+	}
+	return Hash(e)
+}
+
+func (x *expressionIndex) equal(a, b Expression) bool {
+	if x.commutative {
+		return EqualCommutative(a, b)
+	}
+	return Equal(a, b)
+}
+
+//Find returns the number of an expression Equal to e if there is one
+func (x *expressionIndex) Find(e Expression) (int, bool) {
+	for _, i := range x.byHash[x.hash(e)] {
+		if x.equal(x.expressions[i], e) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+//Add returns the number of e, giving it the next number if it is new
+func (x *expressionIndex) Add(e Expression) int {
+	if i, ok := x.Find(e); ok {
+		return i
+	}
+	h := x.hash(e)
+	x.expressions = append(x.expressions, e)
+	x.byHash[h] = append(x.byHash[h], len(x.expressions)-1)
+	return len(x.expressions) - 1
+}
+
+//Len returns how many different expressions have been added
+func (x *expressionIndex) Len() int {
+	return len(x.expressions)
+}
+
+//At returns the expression with number i
+func (x *expressionIndex) At(i int) Expression {
+	return x.expressions[i]
+}
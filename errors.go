@@ -0,0 +1,104 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+//ParseErrorKind is the category of problem found while parsing
+type ParseErrorKind int
+
+//Kinds of parse errors
+const (
+	UnknownCharacterError   ParseErrorKind = iota //A character that is not part of any token
+	UnbalancedParenError                          //A ( without a matching ) or the other way around
+	MissingOperandError                           //An operator or function without enough values to work on
+	MissingOperatorError                          //Two values next to each other with nothing to combine them
+	UnknownFunctionError                          //A name called like a function that is not a function
+	InvalidNumberError                            //A number that can not be read, such as 1.2.3
+	WrongArgumentCountError                       //A function called with a different number of arguments than it takes
+	MisplacedSeparatorError                       //A comma that is not separating the arguments of a function
+)
+
+//String returns a description of the kind of error
+func (k ParseErrorKind) String() string {
+	switch k {
+	case UnknownCharacterError:
+		return "unknown character"
+	case UnbalancedParenError:
+		return "unbalanced parenthesis"
+	case MissingOperandError:
+		return "missing operand"
+	case MissingOperatorError:
+		return "missing operator"
+	case UnknownFunctionError:
+		return "unknown function"
+	case InvalidNumberError:
+		return "invalid number"
+	case WrongArgumentCountError:
+		return "wrong number of arguments"
+	case MisplacedSeparatorError:
+		return "misplaced separator"
+	}
+	return fmt.Sprintf("parse error %d", int(k))
+}
+
+//ParseError describes where and why an expression failed to parse
+type ParseError struct {
+	Kind ParseErrorKind
+	//Offset is the byte offset into Source of the offending token
+	Offset int
+	//Token is the text of the offending token. It is empty when the problem is at the end of the input
+	Token string
+	//Source is the expression that was being parsed
+	Source string
+}
+
+//Error returns a one line description of the error
+func (e *ParseError) Error() string {
+	if e.Token == "" {
+		return fmt.Sprintf("%s at offset %d", e.Kind, e.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d: '%s'", e.Kind, e.Offset, e.Token)
+}
+
+//Caret returns the line of the source containing the error with a ^ under the offending spot
+//
+//	2 + % 3
+//	    ^
+func (e *ParseError) Caret() string {
+	offset := e.Offset
+	if offset > len(e.Source) {
+		offset = len(e.Source)
+	}
+	lineStart := strings.LastIndex(e.Source[:offset], "\n") + 1
+	lineEnd := strings.Index(e.Source[offset:], "\n")
+	if lineEnd < 0 {
+		lineEnd = len(e.Source)
+	} else {
+		lineEnd += offset
+	}
+	line := e.Source[lineStart:lineEnd]
+
+	//Keep tabs so the caret lines up with the source when printed
+	padding := strings.Builder{}
+	for _, r := range e.Source[lineStart:offset] {
+		if r == '\t' {
+			padding.WriteRune('\t')
+		} else {
+			padding.WriteRune(' ')
+		}
+	}
+	return line + "\n" + padding.String() + "^"
+}
+
+//UnboundVariableError is returned when evaluating an expression without a value for all of its variables
+type UnboundVariableError struct {
+	//Symbols are the missing variables in sorted order
+	Symbols []string
+}
+
+//Error lists the missing variables
+func (e *UnboundVariableError) Error() string {
+	return "unbound variables: " + strings.Join(e.Symbols, ", ")
+}
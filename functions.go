@@ -0,0 +1,401 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+//Variadic is the arity of a function that takes one or more arguments
+const Variadic = -1
+
+//Function describes a function that can be called by name in an expression
+type Function struct {
+	Name string
+	//Arity is the number of arguments the function takes or Variadic
+	Arity int
+	//Eval computes the function from the values of its arguments. It must not keep args
+	Eval func(args []float64) float64
+	//Partial returns the partial derivative of the function with respect to argument i
+	//If it is nil the function can not be differentiated and derivatives of it evaluate to NaN
+	Partial func(args []Expression, i int) Expression
+	//Latex is a fmt format string that is given the latex of each argument, such as \sigma\left(%s\right)
+	//A Variadic function is given the latex of all its arguments joined by commas as one argument instead
+	//If it is empty the function is written as \operatorname{name}(args)
+	Latex string
+
+	//build makes a dedicated node for functions that have one such as sin
+	build func(args []Expression) Expression
+}
+
+//acceptsArgs reports whether the function can be called with n arguments
+func (f *Function) acceptsArgs(n int) bool {
+	if f.Arity == Variadic {
+		return n > 0
+	}
+	return n == f.Arity
+}
+
+//call makes the node for calling the function with args
+func (f *Function) call(args []Expression) Expression {
+	if f.build != nil {
+		return f.build(args)
+	}
+	return FunctionCall{
+		Func: f,
+		Args: args,
+	}
+}
+
+var builtinFunctions = map[string]*Function{
+	"sin": {
+		Name:  "sin",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Sin(args[0]) },
+		build: func(args []Expression) Expression { return Siner{args[0]} },
+	},
+	"cos": {
+		Name:  "cos",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Cos(args[0]) },
+		build: func(args []Expression) Expression { return Coser{args[0]} },
+	},
+	"ln": {
+		Name:  "ln",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Log(args[0]) },
+		build: func(args []Expression) Expression { return NaturalLogger{args[0]} },
+	},
+	"tan": {
+		Name:  "tan",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Tan(args[0]) },
+		build: func(args []Expression) Expression { return Tanner{args[0]} },
+	},
+	"exp": {
+		Name:  "exp",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Exp(args[0]) },
+		build: func(args []Expression) Expression { return Exponentiator{args[0]} },
+	},
+	"sqrt": {
+		Name:  "sqrt",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Sqrt(args[0]) },
+		build: func(args []Expression) Expression { return SquareRooter{args[0]} },
+	},
+	"abs": {
+		Name:  "abs",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Abs(args[0]) },
+		build: func(args []Expression) Expression { return AbsoluteValuer{args[0]} },
+	},
+	"log10": {
+		Name:  "log10",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Log10(args[0]) },
+		build: func(args []Expression) Expression { return CommonLogger{args[0]} },
+	},
+	"log2": {
+		Name:  "log2",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Log2(args[0]) },
+		build: func(args []Expression) Expression { return BinaryLogger{args[0]} },
+	},
+	"asin": {
+		Name:  "asin",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Asin(args[0]) },
+		build: func(args []Expression) Expression { return ArcSiner{args[0]} },
+	},
+	"acos": {
+		Name:  "acos",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Acos(args[0]) },
+		build: func(args []Expression) Expression { return ArcCoser{args[0]} },
+	},
+	"atan": {
+		Name:  "atan",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Atan(args[0]) },
+		build: func(args []Expression) Expression { return ArcTanner{args[0]} },
+	},
+	"sinh": {
+		Name:  "sinh",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Sinh(args[0]) },
+		build: func(args []Expression) Expression { return HyperbolicSiner{args[0]} },
+	},
+	"cosh": {
+		Name:  "cosh",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Cosh(args[0]) },
+		build: func(args []Expression) Expression { return HyperbolicCoser{args[0]} },
+	},
+	"tanh": {
+		Name:  "tanh",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Tanh(args[0]) },
+		build: func(args []Expression) Expression { return HyperbolicTanner{args[0]} },
+	},
+	"max": {
+		Name:  "max",
+		Arity: Variadic,
+		Latex: `\max\left(%s\right)`,
+		Eval: func(args []float64) float64 {
+			m := args[0]
+			for _, a := range args[1:] {
+				m = math.Max(m, a)
+			}
+			return m
+		},
+		Partial: func(args []Expression, i int) Expression {
+			return extremumPartial(args, i, false)
+		},
+	},
+	"min": {
+		Name:  "min",
+		Arity: Variadic,
+		Latex: `\min\left(%s\right)`,
+		Eval: func(args []float64) float64 {
+			m := args[0]
+			for _, a := range args[1:] {
+				m = math.Min(m, a)
+			}
+			return m
+		},
+		Partial: func(args []Expression, i int) Expression {
+			return extremumPartial(args, i, true)
+		},
+	},
+	"atan2": {
+		Name:  "atan2",
+		Arity: 2,
+		Eval:  func(args []float64) float64 { return math.Atan2(args[0], args[1]) },
+		Partial: func(args []Expression, i int) Expression {
+			y, x := args[0], args[1]
+			//d/dy = x/(x^2+y^2), d/dx = -y/(x^2+y^2)
+			numerator := x
+			if i == 1 {
+				numerator = Negator{y}
+			}
+			return Divider{
+				A: numerator,
+				B: Adder{
+					A: Powerer{Base: x, Exponent: Constant{2}},
+					B: Powerer{Base: y, Exponent: Constant{2}},
+				},
+			}
+		},
+	},
+	"log": {
+		Name:  "log",
+		Arity: 2,
+		Eval:  func(args []float64) float64 { return math.Log(args[0]) / math.Log(args[1]) },
+		Partial: func(args []Expression, i int) Expression {
+			x, b := args[0], args[1]
+			if i == 0 {
+				//d/dx = 1/(x*ln(b))
+				return Divider{
+					A: Constant{1},
+					B: Multiplier{A: x, B: NaturalLogger{b}},
+				}
+			}
+			//d/db = -ln(x)/(b*ln(b)^2)
+			return Divider{
+				A: Negator{NaturalLogger{x}},
+				B: Multiplier{
+					A: b,
+					B: Powerer{Base: NaturalLogger{b}, Exponent: Constant{2}},
+				},
+			}
+		},
+	},
+	"clamp": {
+		Name:  "clamp",
+		Arity: 3,
+		Eval:  func(args []float64) float64 { return math.Max(args[1], math.Min(args[0], args[2])) },
+		Partial: func(args []Expression, i int) Expression {
+			//clamp(x, low, high) is x between low and high, low below them and high above them, taking low <= high
+			x, low, high := args[0], args[1], args[2]
+			switch i {
+			case 0:
+				return Multiplier{A: step(Subtractor{A: x, B: low}), B: step(Subtractor{A: high, B: x})}
+			case 1:
+				return step(Subtractor{A: low, B: x})
+			}
+			return step(Subtractor{A: x, B: high})
+		},
+	},
+}
+
+//step is 1 where d is positive and 0 where it is negative
+//Like the derivative of abs it is NaN where d is 0
+func step(d Expression) Expression {
+	//(1 + d/|d|) / 2
+	return Divider{
+		A: Adder{
+			A: Constant{1},
+			B: Divider{A: d, B: AbsoluteValuer{d}},
+		},
+		B: Constant{2},
+	}
+}
+
+//extremumPartial is the partial derivative of max(args...), or min(args...) if smallest is set, with respect to argument i
+//That is 1 where argument i is larger, or smaller, than all of the others and 0 elsewhere
+func extremumPartial(args []Expression, i int, smallest bool) Expression {
+	var partial Expression = Constant{1}
+	for j := range args {
+		if j == i {
+			continue
+		}
+		var d Expression = Subtractor{A: args[i], B: args[j]}
+		if smallest {
+			d = Subtractor{A: args[j], B: args[i]}
+		}
+		partial = Multiplier{A: partial, B: step(d)}
+	}
+	return partial
+}
+
+//FunctionRegistry is the set of functions that can be called by name in an expression
+type FunctionRegistry struct {
+	functions map[string]*Function
+}
+
+//NewFunctionRegistry returns a registry holding the built in functions
+func NewFunctionRegistry() *FunctionRegistry {
+	r := &FunctionRegistry{
+		functions: map[string]*Function{},
+	}
+	for name, f := range builtinFunctions {
+		r.functions[name] = f
+	}
+	return r
+}
+
+//defaultFunctions are the functions used when no registry is given to ParseExpression
+var defaultFunctions = NewFunctionRegistry()
+
+//Register adds f to the registry, replacing any function with the same name
+func (r *FunctionRegistry) Register(f Function) error {
+	if !isValidFunctionName(f.Name) {
+		return fmt.Errorf("invalid function name '%s'", f.Name)
+	}
+	if f.Arity < 0 && f.Arity != Variadic {
+		return fmt.Errorf("invalid arity %d for function '%s'", f.Arity, f.Name)
+	}
+	if f.Eval == nil {
+		return fmt.Errorf("function '%s' has no Eval", f.Name)
+	}
+	r.functions[f.Name] = &f
+	return nil
+}
+
+//Lookup finds the function called name
+func (r *FunctionRegistry) Lookup(name string) (*Function, bool) {
+	f, ok := r.functions[name]
+	return f, ok
+}
+
+//isFunctionName reports whether name should be read as a function
+func (r *FunctionRegistry) isFunctionName(name string) bool {
+	_, ok := r.functions[name]
+	return ok
+}
+
+//isValidFunctionName reports whether the tokenizer can read name as one word
+//That is letters and underscores optionally followed by digits
+func isValidFunctionName(name string) bool {
+	letters := strings.TrimRight(name, "0123456789")
+	if letters == "" {
+		return false
+	}
+	for _, r := range letters {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+//FunctionCall calls a function with any number of arguments
+type FunctionCall struct {
+	Func *Function
+	Args []Expression
+}
+
+//Evaluate evaluates the arguments and calls the function with them
+func (f FunctionCall) Evaluate(vars map[string]float64) float64 {
+	args := make([]float64, len(f.Args))
+	for i := range f.Args {
+		args[i] = f.Args[i].Evaluate(vars)
+	}
+	return f.Func.Eval(args)
+}
+
+//String returns a string representation of f(a, b, ...)
+func (f FunctionCall) String() string {
+	args := make([]string, len(f.Args))
+	for i := range f.Args {
+		args[i] = f.Args[i].String()
+	}
+	return f.Func.Name + "(" + strings.Join(args, ", ") + ")"
+}
+
+//Latex returns a latex representation of f(a, b, ...)
+func (f FunctionCall) Latex() string {
+	if f.Func.Latex != "" && f.Func.Arity != Variadic {
+		args := make([]interface{}, len(f.Args))
+		for i := range f.Args {
+			args[i] = f.Args[i].Latex()
+		}
+		return fmt.Sprintf(f.Func.Latex, args...)
+	}
+	args := make([]string, len(f.Args))
+	for i := range f.Args {
+		args[i] = f.Args[i].Latex()
+	}
+	if f.Func.Latex != "" {
+		//The template of a variadic function has one verb for all of the arguments
+		return fmt.Sprintf(f.Func.Latex, strings.Join(args, ", "))
+	}
+	return "\\operatorname{" + f.Func.Name + "}\\left(" + strings.Join(args, ", ") + "\\right)"
+}
+
+//Derive takes the derivative of f(a, b, ...) using the chain rule
+//df = df/da * da + df/db * db + ...
+func (f FunctionCall) Derive(wrt string) Expression {
+	return f.derive(wrt).Simplify()
+}
+
+//derive is Derive without simplifying the result
+func (f FunctionCall) derive(wrt string) Expression {
+	if f.Func.Partial == nil {
+		return Constant{math.NaN()}
+	}
+	var sum Expression = Constant{0}
+	for i := range f.Args {
+		sum = Adder{
+			A: sum,
+			B: Multiplier{
+				A: f.Func.Partial(f.Args, i),
+				B: DeriveRaw(f.Args[i], wrt),
+			},
+		}
+	}
+	return sum
+}
+
+//Compile compiles f(a, b, ...) to bytecode
+func (f FunctionCall) Compile(mm *MemoryManager) int {
+	argResults := make([]int, len(f.Args))
+	for i := range f.Args {
+		argResults[i] = f.Args[i].Compile(mm)
+	}
+	callIndex := mm.AddCall(f.Func, argResults)
+	myResultIndex := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: CallBytecode, A: callIndex, Dst: myResultIndex})
+	return myResultIndex
+}
@@ -1,158 +1,177 @@
 package parser
 
 import (
-	"fmt"
-	"syscall"
-	"unsafe"
+	"math"
+	"runtime"
 )
 
-//PrintHexBytes prints out the byes in a hex format
-func PrintHexBytes(s []byte) {
-	fmt.Print("[")
-	for i := range s {
-		fmt.Printf("0x%x ", s[i])
+//jitStep runs machine code on its operating memory
+type jitStep func(mem []float64) float64
+
+//jitCode is a program compiled to a single machine code function
+//The machine code can not call Go, so a program with an instruction that has no machine code, such as sin, pow or a
+//function call, is run by the interpreter instead and native is nil. Leaving the machine code to run those in Go and
+//entering it again costs more than the machine code saves, so the interpreter is faster for such programs
+type jitCode struct {
+	program *Program
+	native  jitStep
+	block   *codeBlock
+}
+
+//run runs the code on mem
+func (c *jitCode) run(mem []float64) {
+	if c.native == nil {
+		c.program.run(mem)
+		return
 	}
-	fmt.Println("]")
+	c.native(mem)
+}
+
+//JitFunction is an expression compiled to machine code
+//Its memory is released by Close or when it is garbage collected
+//It can be evaluated by several goroutines at once but not while it is being closed
+type JitFunction struct {
+	//program gives the registers and the scratch memory the code runs in
+	program *Program
+	code    *jitCode
+	closed  bool
 }
 
-//JitCompileExpression compiles the expression to jitable code
-func JitCompileExpression(e Expression) func(vs map[string]float64) float64 {
+//NewJitFunction compiles the expression to machine code
+func NewJitFunction(e Expression, opts ...CompileOption) (*JitFunction, error) {
 	mm := NewMemoryManager()
 	//Compile to intermediate bytecode
-	e.Compile(&mm)
-	//Compile to jitable code
-	f := JitCompile(&mm)
-	return f
+	resultIndex := e.Compile(&mm)
+	return newJitFunction(&mm, resultIndex, opts)
 }
 
-var header = []uint8{0x48, 0x83, 0xec, 0x18, 0x48, 0x89, 0x6c, 0x24, 0x10, 0x48, 0x8d, 0x6c, 0x24, 0x10, 0x48, 0x89,
-	0x44, 0x24, 0x20, 0x48, 0x85, 0xdb, 0x76, 0x43}
-var footer = []uint8{0x48, 0x8b, 0x6c, 0x24,
-	0x10, 0x48, 0x83, 0xc4, 0x18, 0x90, 0xc3, 0xb8, 0x02, 0x00, 0x00, 0x00, 0x48, 0x89, 0xd9, 0xe8}
-
-//JitCompile Takes a completed memory manager of intermediate bytecode to jitable code
-func JitCompile(mm *MemoryManager) func(vs map[string]float64) float64 {
-	//Track of which vars are needed
-	vars := make([]string, len(mm.varLocations))
-	i := 0
-	for k := range mm.varLocations {
-		vars[i] = k
-		i++
+func newJitFunction(mm *MemoryManager, resultIndex int, opts []CompileOption) (*JitFunction, error) {
+	p, err := NewProgram(mm, resultIndex, opts...)
+	if err != nil {
+		return nil, err
+	}
+	code, err := jitCompile(p)
+	if err != nil {
+		return nil, err
+	}
+	f := &JitFunction{
+		program: p,
+		code:    code,
 	}
+	if code.block != nil {
+		runtime.SetFinalizer(f, (*JitFunction).Close)
+	}
+	return f, nil
+}
 
-	//Create operating memory (memory in which operations are performed. only parts can be overwritten, others must stay unchanged for the function to wo0rk muiltiple times)
-	operating := make([]float64, len(mm.constants))
-	for i := range operating {
-		operating[i] = mm.constants[i]
+//Evaluate runs the machine code with the values of the variables in vs
+func (f *JitFunction) Evaluate(vs map[string]float64) float64 {
+	if f.closed {
+		panic("jit: JitFunction used after Close")
 	}
-	code := []uint8{}
-	code = append(code, header...)
-	//Translate intermediate representation into x86 Assembly
-	/*
-		for i := 0; i < len(mm.bc); {
-			ins := mm.bc[i]
-			switch ins {
-			case AddBytecode:
-				Ai := mm.bc[i+1]
-				Bi := mm.bc[i+2]
-				Ri := mm.bc[i+3]
-
-				//float64s are 8 byte long
-				AiMem := uint8(Ai) * 8
-				BiMem := uint8(Bi) * 8
-				RiMem := uint8(Ri) * 8
-
-				tempCode := []uint8{
-					//Load A from memory
-					0xf2, 0x0f, 0x10, 0x40, AiMem,
-
-					//Add B from memory to A
-					0xf2, 0x0f, 0x58, 0x40, BiMem,
-
-					//Save to R in memory
-					0xf2, 0x0f, 0x11, 0x40, RiMem,
-				}
-				code = append(code, tempCode...)
-				i += 4
-			case SubBytecode:
-				Ai := mm.bc[i+1]
-				Bi := mm.bc[i+2]
-				Ri := mm.bc[i+3]
-
-				//float64s are 8 byte long
-				AiMem := uint8(Ai) * 8
-				BiMem := uint8(Bi) * 8
-				RiMem := uint8(Ri) * 8
-
-				tempCode := []uint8{
-					//Load A from memory
-					0xf2, 0x0f, 0x10, 0x40, AiMem,
-
-					//Sub B from memory from A
-					0xf2, 0x0f, 0x5c, 0x40, BiMem,
-
-					//Save to R in memory
-					0xf2, 0x0f, 0x11, 0x40, RiMem,
-				}
-				code = append(code, tempCode...)
-				i += 4
-			default:
-				fmt.Println("this shouldnt happen. Unrecognized function:", ins)
-				return nil
-			}
-
-		}
-	*/
-
-	code = append(code, footer...)
-
-	AsmFunction := MakeMathFunc(code)
-	if AsmFunction == nil {
-		panic(fmt.Errorf("should not happen. Nil function"))
+	p := f.program
+	mem := p.getScratch()
+	//Place variables in operating memory
+	for i, k := range p.varNames {
+		(*mem)[p.varRegisters[i]] = vs[k]
 	}
-	fmt.Println(&AsmFunction)
-	return func(vs map[string]float64) float64 {
-		//Place variables in operating memory
-		for _, k := range vars {
-			index := mm.varLocations[k]
-			val := vs[k]
-			operating[index] = val
-
-		}
-		fmt.Println("operating mem", operating)
-		fmt.Println("executing")
-		res := AsmFunction(operating)
-		fmt.Println("result")
-		fmt.Println(res)
-		return res
+	return f.finish(mem)
+}
+
+//EvaluateArgs runs the machine code with args as the values of the variables in the order of Variables
+//It panics if there are not as many args as variables
+func (f *JitFunction) EvaluateArgs(args []float64) float64 {
+	if f.closed {
+		panic("jit: JitFunction used after Close")
 	}
+	p := f.program
+	p.checkArgs(args)
+	mem := p.getScratch()
+	for i, r := range p.varRegisters {
+		(*mem)[r] = args[i]
+	}
+	return f.finish(mem)
 }
 
-//MakeMathFunc takes a bytecode and data and turns it into a function
-func MakeMathFunc(mathFunction []uint8) func([]float64) float64 {
-	type floatFunc func([]float64) float64
+//finish runs the code on mem once the variables are set and gives mem back
+func (f *JitFunction) finish(mem *[]float64) float64 {
+	if f.code.block != nil {
+		f.code.block.enter()
+	}
+	f.code.run(*mem)
+	res := (*mem)[f.program.result]
+	f.program.scratch.Put(mem)
+	//The code must not be released by the finalizer while it is running
+	runtime.KeepAlive(f)
+	return res
+}
 
-	fmt.Println("function length", len(mathFunction))
-	if len(mathFunction) > 128 {
-		panic(fmt.Errorf("function too long for memory alloted"))
+//Variables returns the variables of the function in the order EvaluateArgs takes them, which is sorted
+func (f *JitFunction) Variables() []string {
+	return f.program.Variables()
+}
+
+//Close releases the memory holding the machine code. The function can not be used afterwards
+func (f *JitFunction) Close() {
+	if f.closed {
+		return
 	}
-	PrintHexBytes(mathFunction)
-
-	executablePrintFunc, err := syscall.Mmap(
-		-1,
-		0,
-		128,
-		syscall.PROT_READ|syscall.PROT_WRITE|syscall.PROT_EXEC,
-		syscall.MAP_PRIVATE|syscall.MAP_ANONYMOUS)
-	if err != nil {
-		fmt.Printf("mmap err: %v", err)
+	f.closed = true
+	runtime.SetFinalizer(f, nil)
+	if f.code.block != nil {
+		f.code.block.release()
 	}
+	f.code = nil
+}
+
+//JitCompileExpression compiles the expression to machine code
+//The memory is released once the returned function is garbage collected
+func JitCompileExpression(e Expression, opts ...CompileOption) func(vs map[string]float64) float64 {
+	mm := NewMemoryManager()
+	//Compile to intermediate bytecode
+	resultIndex := e.Compile(&mm)
+	//Compile to machine code
+	return JitCompile(&mm, resultIndex, opts...)
+}
 
-	copy(executablePrintFunc, mathFunction) ///When going back this is where it gets switched out for debug function
+//JitCompileExpressionArgs compiles the expression to machine code like JitCompileExpression but the function
+//takes the values of the variables as a slice in the order of the returned names instead of a map
+func JitCompileExpressionArgs(e Expression, opts ...CompileOption) ([]string, func(args []float64) float64) {
+	f, err := NewJitFunction(e, opts...)
+	if err != nil {
+		panic(err)
+	}
+	return f.Variables(), f.EvaluateArgs
+}
 
-	PrintHexBytes(executablePrintFunc)
+//JitCompile takes a completed memory manager of intermediate bytecode and compiles it to machine code
+//The returned function leaves what is in resultIndex once the code is run
+//Platforms without a code generator, and programs with instructions that have no machine code, are run by the interpreter instead
+//It panics if the code is malformed or there is no memory for it, NewJitFunction returns the error instead
+func JitCompile(mm *MemoryManager, resultIndex int, opts ...CompileOption) func(vs map[string]float64) float64 {
+	f, err := newJitFunction(mm, resultIndex, opts)
+	if err != nil {
+		panic(err)
+	}
+	return f.Evaluate
+}
 
-	unsafePrintFunc := (uintptr)(unsafe.Pointer(&executablePrintFunc))
-	function := *(*floatFunc)(unsafe.Pointer(&unsafePrintFunc))
-	return function
+//unaryFunctions are the functions of the instructions that read one register
+var unaryFunctions = map[Bytecode]func(float64) float64{
+	CosBytecode:   math.Cos,
+	SinBytecode:   math.Sin,
+	LNBytecode:    math.Log,
+	NegBytecode:   func(a float64) float64 { return -a },
+	TanBytecode:   math.Tan,
+	ExpBytecode:   math.Exp,
+	SqrtBytecode:  math.Sqrt,
+	AbsBytecode:   math.Abs,
+	Log10Bytecode: math.Log10,
+	Log2Bytecode:  math.Log2,
+	AsinBytecode:  math.Asin,
+	AcosBytecode:  math.Acos,
+	AtanBytecode:  math.Atan,
+	SinhBytecode:  math.Sinh,
+	CoshBytecode:  math.Cosh,
+	TanhBytecode:  math.Tanh,
 }
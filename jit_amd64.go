@@ -0,0 +1,140 @@
+//go:build amd64 && (linux || darwin)
+// +build amd64
+// +build linux darwin
+
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"unsafe"
+)
+
+//The generated code is called like a Go func([]float64) float64
+//Go passes the pointer to the slice's data in RAX so operand i is at [RAX + i*8]
+//It only uses XMM0 and RCX, both of which Go lets a function clobber, and never touches the stack
+//so it needs no frame and can not be preempted or grow the stack while it runs
+//A program is one function. Calling Go from the machine code is not possible as the Go runtime can not walk or move
+//a stack holding a frame it does not know, so programs with instructions without an SSE2 equivalent such as sin or pow
+//are left to the interpreter
+
+const (
+	//prefix and opcodes of scalar double instructions
+	sdPrefix   = 0xf2
+	movsdLoad  = 0x10
+	movsdStore = 0x11
+	addsd      = 0x58
+	subsd      = 0x5c
+	mulsd      = 0x59
+	divsd      = 0x5e
+	sqrtsd     = 0x51
+
+	//ModRM byte for [RAX + disp32] with register 0 (XMM0) or register 1 (RCX) as the other operand
+	modRMReg0 = 0x80
+	modRMReg1 = 0x88
+
+	ret = 0xc3
+)
+
+var arithmeticOps = map[Bytecode]byte{
+	AddBytecode: addsd,
+	SubBytecode: subsd,
+	MulBytecode: mulsd,
+	DivBytecode: divsd,
+}
+
+//jitCompile compiles the program to one machine code function
+//It leaves the program to the interpreter if any of its instructions has no machine code
+func jitCompile(p *Program) (*jitCode, error) {
+	c := &jitCode{program: p}
+	if len(p.code) == 0 {
+		return c, nil
+	}
+	code := []byte{}
+	for _, ins := range p.code {
+		if code = emitInstruction(code, ins); code == nil {
+			return c, nil
+		}
+	}
+	code = append(code, ret)
+
+	block, err := jitCache.alloc(code)
+	if err != nil {
+		return nil, err
+	}
+	c.block = block
+	c.native = funcAt(block.start)
+	return c, nil
+}
+
+//emitInstruction appends the machine code for the instruction to code
+//It returns nil if the instruction has no machine code
+func emitInstruction(code []byte, ins Instruction) []byte {
+	switch ins.Op {
+	case AddBytecode, SubBytecode, MulBytecode, DivBytecode:
+		//xmm0 = A; xmm0 op= B; Dst = xmm0
+		code = emitSD(code, movsdLoad, modRMReg0, ins.A)
+		code = emitSD(code, arithmeticOps[ins.Op], modRMReg0, ins.B)
+		code = emitSD(code, movsdStore, modRMReg0, ins.Dst)
+		return code
+	case SqrtBytecode:
+		//xmm0 = sqrt(A); Dst = xmm0
+		code = emitSD(code, sqrtsd, modRMReg0, ins.A)
+		code = emitSD(code, movsdStore, modRMReg0, ins.Dst)
+		return code
+	case NegBytecode, AbsBytecode:
+		//Flip or clear the sign bit in a general purpose register
+		//rcx = A; btc/btr rcx, 63; Dst = rcx
+		code = emitRCX(code, 0x8b, ins.A)
+		if ins.Op == NegBytecode {
+			code = append(code, 0x48, 0x0f, 0xba, 0xf9, 63)
+		} else {
+			code = append(code, 0x48, 0x0f, 0xba, 0xf1, 63)
+		}
+		code = emitRCX(code, 0x89, ins.Dst)
+		return code
+	}
+	return nil
+}
+
+//emitSD appends a scalar double instruction with a memory operand at index
+func emitSD(code []byte, op byte, modRM byte, index int) []byte {
+	code = append(code, sdPrefix, 0x0f, op, modRM)
+	return appendDisp(code, index)
+}
+
+//emitRCX appends a 64 bit move between RCX and the memory at index
+func emitRCX(code []byte, op byte, index int) []byte {
+	code = append(code, 0x48, op, modRMReg1)
+	return appendDisp(code, index)
+}
+
+func appendDisp(code []byte, index int) []byte {
+	disp := int64(index) * 8
+	if disp < 0 || disp > 1<<31-1 {
+		panic(fmt.Errorf("jit: memory location %d out of range", index))
+	}
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], uint32(disp))
+	return append(code, buf[:]...)
+}
+
+//funcAt makes a Go function that runs the machine code at addr
+//A func value points to a cell holding the address of the code so make one of those
+func funcAt(addr uintptr) jitStep {
+	cell := new(uintptr)
+	*cell = addr
+	return *(*jitStep)(unsafe.Pointer(&cell))
+}
+
+//MakeMathFunc takes machine code and turns it into a function
+//The code gets the data pointer of the slice in RAX and returns the result in XMM0
+//Its memory is never released, NewJitFunction gives functions that can be closed
+func MakeMathFunc(mathFunction []uint8) func([]float64) float64 {
+	block, err := jitCache.alloc(mathFunction)
+	if err != nil {
+		panic(err)
+	}
+	block.enter()
+	return funcAt(block.start)
+}
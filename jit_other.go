@@ -0,0 +1,27 @@
+//go:build !(amd64 && (linux || darwin))
+// +build !amd64 !linux,!darwin
+
+package parser
+
+import (
+	"fmt"
+	"runtime"
+)
+
+//jitCompile leaves the program to the interpreter as there is no code generator for this platform
+func jitCompile(p *Program) (*jitCode, error) {
+	return &jitCode{program: p}, nil
+}
+
+//codeBlock would hold machine code but there never is any
+type codeBlock struct{}
+
+func (b *codeBlock) enter() {}
+
+func (b *codeBlock) release() {}
+
+//MakeMathFunc takes machine code and turns it into a function
+//There is no way to run machine code on this platform so it panics
+func MakeMathFunc(mathFunction []uint8) func([]float64) float64 {
+	panic(fmt.Errorf("jit: can not run machine code on %s/%s", runtime.GOOS, runtime.GOARCH))
+}
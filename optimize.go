@@ -0,0 +1,170 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// CompileOption changes how an expression is compiled
+type CompileOption func(*compileOptions)
+
+type compileOptions struct {
+	optimize bool
+}
+
+func defaultCompileOptions() compileOptions {
+	return compileOptions{
+		optimize: true,
+	}
+}
+
+func makeCompileOptions(opts []CompileOption) compileOptions {
+	options := defaultCompileOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// WithOptimization turns the optimization of compiled code on or off. It is on by default
+// Turning it off keeps an instruction and a register for every node of the expression, which can help when debugging
+func WithOptimization(enabled bool) CompileOption {
+	return func(o *compileOptions) {
+		o.optimize = enabled
+	}
+}
+
+// optimize folds instructions whose operands are constants, merges instructions that compute the same value
+// and removes instructions whose results are never used
+// The program must be valid
+func (p *Program) optimize() {
+	//alias[r] is the register holding the same value as r
+	alias := make([]int, len(p.memory))
+	for r := range alias {
+		alias[r] = r
+	}
+	constant := make([]bool, len(p.memory))
+	for r := range constant {
+		constant[r] = true
+	}
+	for _, r := range p.varLocations {
+		constant[r] = false
+	}
+	for _, ins := range p.code {
+		constant[ins.Dst] = false
+	}
+	//Registers holding the same constant are merged, by the bits of the value so 0 and -0 stay apart
+	constants := map[uint64]int{}
+	addConstant := func(r int) {
+		bits := math.Float64bits(p.memory[r])
+		if first, ok := constants[bits]; ok {
+			alias[r] = first
+		} else {
+			constants[bits] = r
+		}
+	}
+	for r := range p.memory {
+		if constant[r] {
+			addConstant(r)
+		}
+	}
+
+	//Fold by running the instruction on its own in memory with room for the arguments of calls
+	fold := &Program{memory: p.memory, calls: p.calls}
+	foldMem := make([]float64, len(p.memory)+p.maxCallArgs())
+	copy(foldMem, p.memory)
+
+	type valueKey struct {
+		op   Bytecode
+		a, b int
+		call string
+	}
+	seen := map[valueKey]int{}
+	code := make([]Instruction, 0, len(p.code))
+	calls := make([]call, len(p.calls))
+	copy(calls, p.calls)
+	for _, ins := range p.code {
+		key := valueKey{op: ins.Op}
+		var reads []int
+		switch ins.Op.operands() {
+		case 0:
+			c := call{fn: calls[ins.A].fn, args: make([]int, len(calls[ins.A].args))}
+			var sb strings.Builder
+			fmt.Fprintf(&sb, "%p", c.fn)
+			for i, r := range calls[ins.A].args {
+				c.args[i] = alias[r]
+				fmt.Fprintf(&sb, ",%d", c.args[i])
+			}
+			calls[ins.A] = c
+			reads = c.args
+			key.call = sb.String()
+		case 1:
+			ins.A = alias[ins.A]
+			reads = []int{ins.A}
+			key.a = ins.A
+		case 2:
+			ins.A, ins.B = alias[ins.A], alias[ins.B]
+			reads = []int{ins.A, ins.B}
+			key.a, key.b = ins.A, ins.B
+			if (ins.Op == AddBytecode || ins.Op == MulBytecode) && key.b < key.a {
+				key.a, key.b = key.b, key.a
+			}
+		}
+
+		allConstant := true
+		for _, r := range reads {
+			allConstant = allConstant && constant[r]
+		}
+		if allConstant {
+			fold.code = []Instruction{ins}
+			fold.calls = calls
+			fold.run(foldMem)
+			p.memory[ins.Dst] = foldMem[ins.Dst]
+			constant[ins.Dst] = true
+			addConstant(ins.Dst)
+			continue
+		}
+		if r, ok := seen[key]; ok {
+			alias[ins.Dst] = r
+			continue
+		}
+		seen[key] = ins.Dst
+		code = append(code, ins)
+	}
+	p.result = alias[p.result]
+
+	//Go backwards keeping the instructions whose results are needed
+	needed := map[int]bool{p.result: true}
+	kept := 0
+	for i := len(code) - 1; i >= 0; i-- {
+		ins := code[i]
+		if !needed[ins.Dst] {
+			continue
+		}
+		switch ins.Op.operands() {
+		case 0:
+			for _, r := range calls[ins.A].args {
+				needed[r] = true
+			}
+		case 1:
+			needed[ins.A] = true
+		case 2:
+			needed[ins.A] = true
+			needed[ins.B] = true
+		}
+		code[len(code)-1-kept] = ins
+		kept++
+	}
+	code = code[len(code)-kept:]
+
+	//Only keep the calls that are still made
+	p.calls = nil
+	for i := range code {
+		if code[i].Op == CallBytecode {
+			p.calls = append(p.calls, calls[code[i].A])
+			code[i].A = len(p.calls) - 1
+		}
+	}
+	p.code = code
+}
@@ -1,9 +1,9 @@
 package parser
 
 import (
-	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 //ElementType is the type of each element
@@ -17,35 +17,125 @@ const (
 	LeftParenType
 	RightParenType
 	FunctionType
+	UnaryOperatorType
+	SeparatorType
 )
 
-//Token is the type of token and the value of the token
+//Token is the type of token, the value of the token and the byte offset it started at in the input
+//For function tokens in postfix order ArgCount is the number of arguments they were called with
 type Token struct {
-	Type  ElementType
-	Value string
+	Type     ElementType
+	Value    string
+	Pos      int
+	ArgCount int
+}
+
+//ParseOption changes how ParseExpression reads its input
+type ParseOption func(*parseOptions)
+
+type parseOptions struct {
+	implicitMultiplication bool
+	functions              *FunctionRegistry
+	//constants may be shared with builtinConstants so options must copy it before changing it
+	constants map[string]float64
+}
+
+func defaultParseOptions() parseOptions {
+	return parseOptions{
+		implicitMultiplication: true,
+		functions:              defaultFunctions,
+		constants:              builtinConstants,
+	}
+}
+
+//WithFunctions makes the parser use the functions in r instead of the built in ones
+//A nil r uses the built in functions
+func WithFunctions(r *FunctionRegistry) ParseOption {
+	return func(o *parseOptions) {
+		if r == nil {
+			r = defaultFunctions
+		}
+		o.functions = r
+	}
+}
+
+//WithImplicitMultiplication turns implicit multiplication (2x, 3(x+1), (a)(b)) on or off. It is on by default
+//When it is off, juxtaposed operands are reported as an error instead
+func WithImplicitMultiplication(enabled bool) ParseOption {
+	return func(o *parseOptions) {
+		o.implicitMultiplication = enabled
+	}
+}
+
+//WithConstants adds names that are read as constants with the given values instead of as variables
+//A name that is already a constant such as e is given the new value
+func WithConstants(constants map[string]float64) ParseOption {
+	return func(o *parseOptions) {
+		o.constants = copyConstants(o.constants)
+		for name, value := range constants {
+			o.constants[name] = value
+		}
+	}
+}
+
+//WithoutConstants makes names that would be constants, such as e, be read as variables
+//Without it a variable named e can not be used, as e is always read as the constant
+func WithoutConstants(names ...string) ParseOption {
+	return func(o *parseOptions) {
+		o.constants = copyConstants(o.constants)
+		for _, name := range names {
+			delete(o.constants, name)
+		}
+	}
+}
+
+func copyConstants(constants map[string]float64) map[string]float64 {
+	c := make(map[string]float64, len(constants))
+	for name, value := range constants {
+		c[name] = value
+	}
+	return c
 }
 
 //ParseExpression parses a string into an executable expression
-func ParseExpression(expr string) (Expression, error) {
-	tokens, err := tokenize(expr)
+func ParseExpression(expr string, opts ...ParseOption) (Expression, error) {
+	options := defaultParseOptions()
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	tokens, err := tokenize(expr, options.functions)
 
 	if err != nil {
-		return nil, err
+		return nil, withSource(err, expr)
+	}
+
+	tokens, err = insertImplicitMultiplication(tokens, options.implicitMultiplication)
+	if err != nil {
+		return nil, withSource(err, expr)
 	}
 
 	postfix, err := makePostFix(tokens)
 	if err != nil {
-		return nil, err
+		return nil, withSource(err, expr)
 	}
 
-	e, err := parsePostfix(postfix)
+	e, err := parsePostfix(postfix, options)
 	if err != nil {
-		return nil, err
+		return nil, withSource(err, expr)
 	}
 	return e, nil
 }
 
-func parsePostfix(tokens []Token) (Expression, error) {
+//withSource attaches the parsed expression to a ParseError so it can show where the error is
+func withSource(err error, expr string) error {
+	if pe, ok := err.(*ParseError); ok {
+		pe.Source = expr
+	}
+	return err
+}
+
+func parsePostfix(tokens []Token, options parseOptions) (Expression, error) {
 	var PartsStack = NewExpressionStack()
 	for i := 0; i < len(tokens); i++ {
 		t := tokens[i]
@@ -53,7 +143,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 		case NumberType:
 			v, err := strconv.ParseFloat(t.Value, 64)
 			if err != nil {
-				return nil, err
+				return nil, &ParseError{
+					Kind:   InvalidNumberError,
+					Offset: t.Pos,
+					Token:  t.Value,
+				}
 			}
 			PartsStack.Push(Constant{
 				Value: v,
@@ -61,11 +155,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 		case OperatorType:
 			switch t.Value {
 			case "+":
-				A, err := PartsStack.Pop()
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				B, err := PartsStack.Pop()
+				B, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
@@ -74,11 +168,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 					B: A,
 				})
 			case "-":
-				B, err := PartsStack.Pop()
+				B, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				A, err := PartsStack.Pop()
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
@@ -87,11 +181,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 					B: B,
 				})
 			case "*":
-				B, err := PartsStack.Pop()
+				B, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				A, err := PartsStack.Pop()
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
@@ -100,11 +194,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 					B: B,
 				})
 			case "/":
-				B, err := PartsStack.Pop()
+				B, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				A, err := PartsStack.Pop()
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
@@ -113,11 +207,11 @@ func parsePostfix(tokens []Token) (Expression, error) {
 					B: B,
 				})
 			case "^":
-				B, err := PartsStack.Pop()
+				B, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				A, err := PartsStack.Pop()
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
@@ -126,55 +220,195 @@ func parsePostfix(tokens []Token) (Expression, error) {
 					Exponent: B,
 				})
 			}
+		case UnaryOperatorType:
+			A, err := popOperand(&PartsStack, t)
+			if err != nil {
+				return nil, err
+			}
+			switch t.Value {
+			case "-":
+				PartsStack.Push(Negator{A})
+			case "+":
+				//Unary plus does nothing to its operand
+				PartsStack.Push(A)
+			}
 		case VariableType:
 			symbol := t.Value
+			if value, ok := options.constants[symbol]; ok {
+				PartsStack.Push(NamedConstant{
+					Name:  symbol,
+					Value: value,
+				})
+				continue
+			}
 			PartsStack.Push(Variable{
 				Symbol: symbol,
 			})
 		case FunctionType:
-			switch t.Value {
-			case "cos":
-				A, err := PartsStack.Pop()
-				if err != nil {
-					return nil, err
+			f, ok := options.functions.Lookup(t.Value)
+			if !ok {
+				return nil, &ParseError{
+					Kind:   UnknownFunctionError,
+					Offset: t.Pos,
+					Token:  t.Value,
 				}
-				PartsStack.Push(Coser{A})
-			case "sin":
-				A, err := PartsStack.Pop()
-				if err != nil {
-					return nil, err
+			}
+			if !f.acceptsArgs(t.ArgCount) {
+				return nil, &ParseError{
+					Kind:   WrongArgumentCountError,
+					Offset: t.Pos,
+					Token:  t.Value,
 				}
-				PartsStack.Push(Siner{A})
-			case "ln":
-				A, err := PartsStack.Pop()
+			}
+			//Arguments come off the stack last first
+			args := make([]Expression, t.ArgCount)
+			for a := t.ArgCount - 1; a >= 0; a-- {
+				A, err := popOperand(&PartsStack, t)
 				if err != nil {
 					return nil, err
 				}
-				PartsStack.Push(NaturalLogger{A})
-
+				args[a] = A
 			}
+			PartsStack.Push(f.call(args))
+		}
+	}
+	if PartsStack.Len() == 0 {
+		//Nothing at all to evaluate such as an empty string or ()
+		return nil, &ParseError{
+			Kind:   MissingOperandError,
+			Offset: lastPos(tokens),
+		}
+	}
+	if PartsStack.Len() > 1 {
+		return nil, &ParseError{
+			Kind:   MissingOperatorError,
+			Offset: lastPos(tokens),
 		}
 	}
 	return PartsStack.Pop()
 }
 
+//popOperand pops the value that t operates on, reporting a missing operand for t if there is none
+func popOperand(s *ExpressionStack, t Token) (Expression, error) {
+	e, err := s.Pop()
+	if err != nil {
+		return nil, &ParseError{
+			Kind:   MissingOperandError,
+			Offset: t.Pos,
+			Token:  t.Value,
+		}
+	}
+	return e, nil
+}
+
+//lastPos returns the position of the last token or 0 if there are none
+func lastPos(tokens []Token) int {
+	if len(tokens) == 0 {
+		return 0
+	}
+	return tokens[len(tokens)-1].Pos
+}
+
+//insertImplicitMultiplication places a * between tokens that are next to each other with no operator between them
+//If allowed is false, it returns an error at the first such pair instead
+func insertImplicitMultiplication(tokens []Token, allowed bool) ([]Token, error) {
+	output := make([]Token, 0, len(tokens))
+	for i := 0; i < len(tokens); i++ {
+		if i > 0 && endsOperand(tokens[i-1]) && startsOperand(tokens[i]) {
+			if !allowed {
+				if tokens[i-1].Type == VariableType && tokens[i].Type == LeftParenType {
+					//Looks like a call to something that is not a function
+					return nil, &ParseError{
+						Kind:   UnknownFunctionError,
+						Offset: tokens[i-1].Pos,
+						Token:  tokens[i-1].Value,
+					}
+				}
+				return nil, &ParseError{
+					Kind:   MissingOperatorError,
+					Offset: tokens[i].Pos,
+					Token:  tokens[i].Value,
+				}
+			}
+			output = append(output, Token{
+				Type:  OperatorType,
+				Value: "*",
+				Pos:   tokens[i].Pos,
+			})
+		}
+		output = append(output, tokens[i])
+	}
+	return output, nil
+}
+
+//endsOperand reports whether t can be the last token of an operand
+func endsOperand(t Token) bool {
+	return t.Type == NumberType || t.Type == VariableType || t.Type == RightParenType
+}
+
+//startsOperand reports whether t can be the first token of an operand
+func startsOperand(t Token) bool {
+	return t.Type == NumberType || t.Type == VariableType || t.Type == LeftParenType || t.Type == FunctionType
+}
+
 func makePostFix(tokens []Token) ([]Token, error) {
 	precedence := map[string]int{
 		"+": 2,
 		"-": 2,
 		"*": 3,
 		"/": 3,
-		"^": 4,
+		"^": 5,
 	}
+	//Prefix operators bind tighter than * and / but looser than ^ so -x^2 is -(x^2)
+	unaryPrecedence := 4
 	output := []Token{}
 
 	operatorStack := NewTokenStack()
+	//For each open parenthesis, whether it holds the arguments of a function call
+	parenIsCall := []bool{}
+	//For each open function call, how many arguments have been started
+	argCounts := []int{}
 	for i := 0; i < len(tokens); i++ {
 		if tokens[i].Type == NumberType || tokens[i].Type == VariableType {
 
 			output = append(output, tokens[i])
-		} else if tokens[i].Type == FunctionType {
+		} else if tokens[i].Type == FunctionType || tokens[i].Type == UnaryOperatorType {
+			if tokens[i].Type == FunctionType && (i+1 >= len(tokens) || tokens[i+1].Type != LeftParenType) {
+				//Functions need their arguments in parentheses
+				return nil, &ParseError{
+					Kind:   MissingOperandError,
+					Offset: tokens[i].Pos,
+					Token:  tokens[i].Value,
+				}
+			}
+			//Prefix operators have nothing to their left to pop so they go straight on the stack
 			operatorStack.Push(tokens[i])
+		} else if tokens[i].Type == SeparatorType {
+			if i == 0 || tokens[i-1].Type == LeftParenType || tokens[i-1].Type == SeparatorType {
+				//Empty argument as in max(,a) or max(a,,b), or nothing before the separator as in ,x
+				return nil, &ParseError{
+					Kind:   MissingOperandError,
+					Offset: tokens[i].Pos,
+					Token:  tokens[i].Value,
+				}
+			}
+			//Finish the previous argument
+			for {
+				o, err := operatorStack.Peek()
+				if err != nil || o.Type == LeftParenType {
+					break
+				}
+				o, _ = operatorStack.Pop()
+				output = append(output, o)
+			}
+			if len(parenIsCall) == 0 || !parenIsCall[len(parenIsCall)-1] {
+				return nil, &ParseError{
+					Kind:   MisplacedSeparatorError,
+					Offset: tokens[i].Pos,
+					Token:  tokens[i].Value,
+				}
+			}
+			argCounts[len(argCounts)-1]++
 		} else if tokens[i].Type == OperatorType {
 
 			o1 := tokens[i]
@@ -189,7 +423,11 @@ func makePostFix(tokens []Token) ([]Token, error) {
 				if o2.Value == "(" { //o2 other than the left parenthesis at the top of the operator stack
 					break
 				}
-				if !(precedence[o2.Value] > precedence[o1.Value] || (precedence[o2.Value] == precedence[o1.Value] && o1.Value != "^")) { // (o2 has greater precedence than o1 or they have the same precedence and o1 is left-associative)
+				o2Precedence := precedence[o2.Value]
+				if o2.Type == UnaryOperatorType {
+					o2Precedence = unaryPrecedence
+				}
+				if !(o2Precedence > precedence[o1.Value] || (o2Precedence == precedence[o1.Value] && o1.Value != "^")) { // (o2 has greater precedence than o1 or they have the same precedence and o1 is left-associative)
 					break
 				}
 				o2_2, _ := operatorStack.Pop()
@@ -197,12 +435,21 @@ func makePostFix(tokens []Token) ([]Token, error) {
 			}
 			operatorStack.Push(o1)
 		} else if tokens[i].Type == LeftParenType {
+			isCall := i > 0 && tokens[i-1].Type == FunctionType
+			parenIsCall = append(parenIsCall, isCall)
+			if isCall {
+				argCounts = append(argCounts, 1)
+			}
 			operatorStack.Push(tokens[i])
 		} else if tokens[i].Type == RightParenType {
 			for {
 				o, err := operatorStack.Peek()
 				if err != nil {
-					return nil, fmt.Errorf("unbalanced parentheses at index %d '%s'. next token: %s", i, tokens[i].Value, o.Value)
+					return nil, &ParseError{
+						Kind:   UnbalancedParenError,
+						Offset: tokens[i].Pos,
+						Token:  tokens[i].Value,
+					}
 				}
 				if o.Type != LeftParenType {
 					o2, _ := operatorStack.Pop()
@@ -214,64 +461,101 @@ func makePostFix(tokens []Token) ([]Token, error) {
 			//{assert there is a left parenthesis at the top of the operator stack}
 			o1, err := operatorStack.Peek()
 			if err != nil || o1.Value != "(" {
-				return nil, fmt.Errorf("should be a left parenthesis here")
+				return nil, &ParseError{
+					Kind:   UnbalancedParenError,
+					Offset: tokens[i].Pos,
+					Token:  tokens[i].Value,
+				}
 			}
 			operatorStack.Pop()
-			//if there is a function token at the top of the operator stack, then:
+			isCall := parenIsCall[len(parenIsCall)-1]
+			parenIsCall = parenIsCall[:len(parenIsCall)-1]
+			//if the parentheses held the arguments of a function, then:
 			//pop the function from the operator stack into the output queue
-			o, err := operatorStack.Peek()
-			if err == nil {
-				if o.Type == FunctionType {
-					o, _ = operatorStack.Pop()
-					output = append(output, o)
+			if isCall {
+				argCount := argCounts[len(argCounts)-1]
+				argCounts = argCounts[:len(argCounts)-1]
+				switch tokens[i-1].Type {
+				case LeftParenType:
+					//Called with no arguments
+					argCount = 0
+				case SeparatorType:
+					//Trailing separator as in max(a,)
+					return nil, &ParseError{
+						Kind:   MissingOperandError,
+						Offset: tokens[i].Pos,
+						Token:  tokens[i].Value,
+					}
 				}
+				o, _ := operatorStack.Pop()
+				o.ArgCount = argCount
+				output = append(output, o)
 			}
 
 		}
 	}
 	for operatorStack.Len() > 0 {
 		o, _ := operatorStack.Pop()
+		if o.Type == LeftParenType {
+			//Never closed
+			return nil, &ParseError{
+				Kind:   UnbalancedParenError,
+				Offset: o.Pos,
+				Token:  o.Value,
+			}
+		}
 		output = append(output, o)
 	}
 	return output, nil
 }
 
-func tokenize(s string) ([]Token, error) {
+func tokenize(s string, functions *FunctionRegistry) ([]Token, error) {
 	varParts := "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_"
-	functions := []string{"cos", "sin", "ln"}
 	numberParts := "1234567890."
 	operators := "+-*/^"
-	s = strings.ReplaceAll(s, " ", "")
+	whitespace := " \t\n\r"
 	tokens := []Token{}
 
 	var MidNumber = false
 	var MidVar = false
 	var currentTokenVal = ""
+	var currentTokenPos = 0
 	for i := 0; i < len(s); i++ {
-		//Error checking
-		if MidNumber && MidVar {
-			return nil, fmt.Errorf("error at index %d, char: %s; a number and a variable together", i, string(s[i]))
-		}
-
 		//Character to analyze
 		var char string = string(s[i])
 
 		//Is part of a number
 		IsNumberPart := strings.ContainsAny(char, numberParts)
+		if IsNumberPart && MidVar {
+			//Digits only continue a name when they make it a function such as atan2, otherwise x2 is x*2
+			digitsEnd := i
+			for digitsEnd < len(s) && s[digitsEnd] >= '0' && s[digitsEnd] <= '9' {
+				digitsEnd++
+			}
+			if digitsEnd > i && functions.isFunctionName(currentTokenVal+s[i:digitsEnd]) {
+				currentTokenVal += s[i:digitsEnd]
+				i = digitsEnd - 1
+				continue
+			}
+		}
 		if IsNumberPart {
 			if MidVar {
 				//Finish variable
 				var typeOf = VariableType
-				if matchesAny(currentTokenVal, functions) {
+				if functions.isFunctionName(currentTokenVal) {
 					typeOf = FunctionType
 				}
 				tokens = append(tokens, Token{
 					Type:  typeOf,
 					Value: currentTokenVal,
+					Pos:   currentTokenPos,
 				})
 				currentTokenVal = ""
 				MidVar = false
 			}
+			if !MidNumber {
+				currentTokenPos = i
+			}
 			currentTokenVal += char
 			MidNumber = true
 			continue
@@ -282,6 +566,7 @@ func tokenize(s string) ([]Token, error) {
 			tokens = append(tokens, Token{
 				Type:  NumberType,
 				Value: currentTokenVal,
+				Pos:   currentTokenPos,
 			})
 			currentTokenVal = ""
 			MidNumber = false
@@ -290,36 +575,45 @@ func tokenize(s string) ([]Token, error) {
 		//Is part of a variable
 		IsVarPart := strings.ContainsAny(char, varParts)
 		if IsVarPart {
+			if !MidVar {
+				currentTokenPos = i
+			}
 			currentTokenVal += char
 			MidVar = true
-			//Check if var so far matches any functions, if it does MidVar = false token = whatever that funciton is
-
 			continue
 		}
 		//End Variable
 		if MidVar && !IsVarPart {
 			//End of variable name
 			var typeOf = VariableType
-			if matchesAny(currentTokenVal, functions) {
+			if functions.isFunctionName(currentTokenVal) {
 				typeOf = FunctionType
 			}
 			tokens = append(tokens, Token{
 				Type:  typeOf,
 				Value: currentTokenVal,
+				Pos:   currentTokenPos,
 			})
 			currentTokenVal = ""
 			MidVar = false
 		}
 
+		//Whitespace only separates tokens
+		if strings.ContainsAny(char, whitespace) {
+			continue
+		}
+
 		IsOperator := strings.ContainsAny(char, operators)
 		if IsOperator {
-
-			currentTokenVal = char
+			typeOf := OperatorType
+			if (char == "-" || char == "+") && isPrefixPosition(tokens) {
+				typeOf = UnaryOperatorType
+			}
 			tokens = append(tokens, Token{
-				Type:  OperatorType,
-				Value: currentTokenVal,
+				Type:  typeOf,
+				Value: char,
+				Pos:   i,
 			})
-			currentTokenVal = ""
 			continue
 		}
 
@@ -327,6 +621,7 @@ func tokenize(s string) ([]Token, error) {
 			tokens = append(tokens, Token{
 				Type:  LeftParenType,
 				Value: "(",
+				Pos:   i,
 			})
 			continue
 		}
@@ -334,35 +629,58 @@ func tokenize(s string) ([]Token, error) {
 			tokens = append(tokens, Token{
 				Type:  RightParenType,
 				Value: ")",
+				Pos:   i,
+			})
+			continue
+		}
+		if char == "," {
+			tokens = append(tokens, Token{
+				Type:  SeparatorType,
+				Value: ",",
+				Pos:   i,
 			})
 			continue
 		}
 
+		r, _ := utf8.DecodeRuneInString(s[i:])
+		return nil, &ParseError{
+			Kind:   UnknownCharacterError,
+			Offset: i,
+			Token:  string(r),
+		}
 	}
 	//Check if it was the end and partway through a number
 	if MidNumber {
 		tokens = append(tokens, Token{
 			Type:  NumberType,
 			Value: currentTokenVal,
+			Pos:   currentTokenPos,
 		})
-		currentTokenVal = ""
 	}
 	if MidVar {
 		//End of variable name
+		var typeOf = VariableType
+		if functions.isFunctionName(currentTokenVal) {
+			typeOf = FunctionType
+		}
 		tokens = append(tokens, Token{
-			Type:  VariableType,
+			Type:  typeOf,
 			Value: currentTokenVal,
+			Pos:   currentTokenPos,
 		})
-		currentTokenVal = ""
 	}
 	return tokens, nil
 }
 
-func matchesAny(s string, substrs []string) bool {
-	for i := 0; i < len(substrs); i++ {
-		if s == substrs[i] {
-			return true
-		}
+//isPrefixPosition reports whether an operator following tokens has no left operand
+//This is the case at the start of the input, after a (, after a separator and after another operator
+func isPrefixPosition(tokens []Token) bool {
+	if len(tokens) == 0 {
+		return true
+	}
+	switch tokens[len(tokens)-1].Type {
+	case OperatorType, UnaryOperatorType, LeftParenType, SeparatorType:
+		return true
 	}
 	return false
 }
@@ -1,8 +1,15 @@
 package parser
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"math"
+	"math/rand"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -73,6 +80,453 @@ func TestCompile5(t *testing.T) {
 	}
 }
 
+func TestDisassemble(t *testing.T) {
+	e, err := ParseExpression("2*x+sin(y)-max(x, 3, -y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CompileProgram(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `r0 = 2
+r1 = 3
+r2 = x
+r3 = y
+r4 = mul r0, r2
+r5 = sin r3
+r5 = add r4, r5
+r4 = neg r3
+r4 = call max(r2, r1, r4)
+r4 = sub r5, r4
+return r4
+`
+	if got := p.Disassemble(); got != want {
+		t.Errorf("Disassembled to\n%s\nwanted\n%s", got, want)
+	}
+}
+
+func TestConcurrentEvaluate(t *testing.T) {
+	e, err := ParseExpression("x*y+sin(x)-max(x, y, 2)/sqrt(abs(y)+1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := CompileExpression(e)
+	jit := JitCompileExpression(e)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				vars := map[string]float64{"x": float64(g), "y": float64(i)}
+				want := e.Evaluate(vars)
+				if res := compiled(vars); res != want {
+					t.Errorf("Compiled with x = %d, y = %d gave %g, wanted %g", g, i, res, want)
+					return
+				}
+				if res := jit(vars); res != want {
+					t.Errorf("Jit compiled with x = %d, y = %d gave %g, wanted %g", g, i, res, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+}
+
+func TestCompileExpressionArgs(t *testing.T) {
+	e, err := ParseExpression("x*y+sin(z)-max(x, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]float64{"x": 3, "y": -1.5, "z": 0.25}
+	want := e.Evaluate(vars)
+	compilers := map[string]func(Expression, ...CompileOption) ([]string, func([]float64) float64){
+		"compiled":     CompileExpressionArgs,
+		"jit compiled": JitCompileExpressionArgs,
+	}
+	for name, compile := range compilers {
+		names, f := compile(e)
+		if fmt.Sprint(names) != "[x y z]" {
+			t.Errorf("%s: variables should be [x y z] but were %v", name, names)
+			continue
+		}
+		args := make([]float64, len(names))
+		for i := range names {
+			args[i] = vars[names[i]]
+		}
+		if res := f(args); res != want {
+			t.Errorf("%s: gave %g, wanted %g", name, res, want)
+		}
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: calling with too few arguments should panic", name)
+				}
+			}()
+			f(args[:2])
+		}()
+	}
+}
+
+func TestCompileExpressionStruct(t *testing.T) {
+	type point struct {
+		X      float64 `expr:"x"`
+		y      float32
+		Weight float64 `expr:"-"`
+		Name   string
+	}
+	e, err := ParseExpression("x^2+y")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := CompileExpressionStruct(e, &point{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := f(&point{X: 3, y: 0.5, Weight: 100}); res != 9.5 {
+		t.Errorf("x^2+y with x = 3 and y = 0.5 gave %g", res)
+	}
+
+	errorTests := []struct {
+		expr   string
+		sample interface{}
+	}{
+		{"Weight", &point{}},
+		{"Name", &point{}},
+		{"w", &point{}},
+		{"x", point{}},
+		{"x", nil},
+	}
+	for _, test := range errorTests {
+		e, err := ParseExpression(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := CompileExpressionStruct(e, test.sample); err == nil {
+			t.Errorf("Binding %s to %T should fail", test.expr, test.sample)
+		}
+	}
+}
+
+func TestEvalBatch(t *testing.T) {
+	e, err := ParseExpression("x*y+sin(x)-max(y, 1)/2+abs(-x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CompileProgram(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//More rows than one chunk and not a multiple of it
+	rows := 1000
+	x := make([]float64, rows)
+	y := make([]float64, rows)
+	for i := range x {
+		x[i] = float64(i) / 10
+		y[i] = float64(rows-i) / 7
+	}
+	out := make([]float64, rows)
+	if err := p.EvalBatch(map[string][]float64{"x": x, "y": y}, out); err != nil {
+		t.Fatal(err)
+	}
+	for i := range out {
+		want := e.Evaluate(map[string]float64{"x": x[i], "y": y[i]})
+		if out[i] != want {
+			t.Errorf("Row %d gave %g, wanted %g", i, out[i], want)
+			break
+		}
+	}
+
+	var unbound *UnboundVariableError
+	if err := p.EvalBatch(map[string][]float64{"x": x}, out); !errors.As(err, &unbound) || fmt.Sprint(unbound.Symbols) != "[y]" {
+		t.Errorf("Missing column should give an UnboundVariableError for y, got %v", err)
+	}
+	if err := p.EvalBatch(map[string][]float64{"x": x, "y": y[:10]}, out); err == nil {
+		t.Errorf("Short column should give an error")
+	}
+
+	//An expression that is just a variable
+	v, _ := ParseExpression("x")
+	vp, _ := CompileProgram(v)
+	if err := vp.EvalBatch(map[string][]float64{"x": x}, out); err != nil || out[999] != x[999] {
+		t.Errorf("Batch of x gave %g, %v, wanted %g", out[999], err, x[999])
+	}
+}
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		expr, want string
+	}{
+		{"sin(x)*sin(x)", `r0 = x
+r1 = sin r0
+r1 = mul r1, r1
+return r1
+`},
+		{"2*3*x", `r0 = 6
+r1 = x
+r2 = mul r0, r1
+return r2
+`},
+		{"x*y+y*x+max(2, 3)*x", `r0 = 3
+r1 = x
+r2 = y
+r3 = mul r1, r2
+r3 = add r3, r3
+r4 = mul r0, r1
+r4 = add r3, r4
+return r4
+`},
+		{"sqrt(4)-2", `r0 = 0
+return r0
+`},
+	}
+	for _, test := range tests {
+		e, err := ParseExpression(test.expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := CompileProgram(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.Disassemble(); got != test.want {
+			t.Errorf("%s optimized to\n%s\nwanted\n%s", test.expr, got, test.want)
+		}
+	}
+
+	//Without optimization sin(x) is computed twice
+	e, _ := ParseExpression("sin(x)*sin(x)")
+	p, _ := CompileProgram(e, WithOptimization(false))
+	if n := len(p.Instructions()); n != 3 {
+		t.Errorf("sin(x)*sin(x) without optimization has %d instructions, wanted 3", n)
+	}
+
+	//Instructions whose results are never used are removed
+	mm := NewMemoryManager()
+	x := mm.AddVariable("x")
+	dead := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: CosBytecode, A: x, Dst: dead})
+	r := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: SinBytecode, A: x, Dst: r})
+	p, err := NewProgram(&mm, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ins := p.Instructions(); len(ins) != 1 || ins[0].Op != SinBytecode {
+		t.Errorf("Dead cos should be removed, got\n%s", p.Disassemble())
+	}
+
+	//Optimized and unoptimized code give the same results
+	vars := map[string]float64{"x": 0.7, "y": -3}
+	for _, q := range []string{
+		"sin(x)*sin(x)+cos(x*y)/cos(y*x)",
+		"0/0+x",
+		"-0*x+1/(-0)",
+		"max(x, 2*3, y)-max(x, 6, y)+clamp(x, 0, 1)",
+		"(x+1)^(2+1)-(1+x)^3",
+		"ln(2)*log(x, 2)+2^10",
+	} {
+		e, err := ParseExpression(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := CompileExpression(e, WithOptimization(false))(vars)
+		if res := CompileExpression(e)(vars); !sameFloat(res, want) {
+			t.Errorf("%s optimized gave %g, wanted %g", q, res, want)
+		}
+		if res := JitCompileExpression(e)(vars); !sameFloat(res, want) {
+			t.Errorf("%s optimized and jit compiled gave %g, wanted %g", q, res, want)
+		}
+	}
+}
+
+func TestMarshalProgram(t *testing.T) {
+	r := NewFunctionRegistry()
+	err := r.Register(Function{
+		Name:  "relu",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Max(0, args[0]) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]float64{"x": 0.5, "y": -2}
+	for _, q := range []string{"5", "x", "2*x+sin(y)-max(x, 3, -y)", "relu(y)+relu(x)*pi", "0/0+x"} {
+		e, err := ParseExpression(q, WithFunctions(r))
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, err := CompileProgram(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := p.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := LoadProgram(data, r)
+		if err != nil {
+			t.Errorf("%s failed to load: %v", q, err)
+			continue
+		}
+		if loaded.Disassemble() != p.Disassemble() {
+			t.Errorf("%s loaded as\n%s\nwanted\n%s", q, loaded.Disassemble(), p.Disassemble())
+		}
+		if res, want := loaded.Evaluate(vars), e.Evaluate(vars); !sameFloat(res, want) {
+			t.Errorf("Loaded %s gave %g, wanted %g", q, res, want)
+		}
+		//Every truncation of the data is rejected without panicking
+		for n := 0; n < len(data); n++ {
+			if _, err := LoadProgram(data[:n], r); err == nil {
+				t.Errorf("%s loaded from %d of %d bytes", q, n, len(data))
+			}
+		}
+	}
+
+	e, _ := ParseExpression("relu(x)+max(x, 1)", WithFunctions(r))
+	p, _ := CompileProgram(e)
+	data, _ := p.MarshalBinary()
+	//relu is not built in
+	var unmarshaled Program
+	if err := unmarshaled.UnmarshalBinary(data); err == nil {
+		t.Errorf("Program calling relu should not load without it")
+	}
+	bad := append([]byte{}, data...)
+	bad[4] = 2
+	if _, err := LoadProgram(bad, r); err == nil {
+		t.Errorf("Program of version 2 should not load")
+	}
+	if _, err := LoadProgram([]byte("JSON{}"), r); err == nil {
+		t.Errorf("Data without the header should not load")
+	}
+	if _, err := LoadProgram(append(data, 0), r); err == nil {
+		t.Errorf("Data with trailing bytes should not load")
+	}
+	//The last byte is the result register
+	bad = append([]byte{}, data...)
+	bad[len(bad)-1] = 100
+	if _, err := LoadProgram(bad, r); err == nil {
+		t.Errorf("Program with result in a register that does not exist should not load")
+	}
+}
+
+func TestRegisterReuse(t *testing.T) {
+	//A sum of a thousand different terms only needs a couple of scratch registers at once
+	var sb strings.Builder
+	sb.WriteString("x")
+	for i := 1; i <= 1000; i++ {
+		fmt.Fprintf(&sb, "+sin(x*%d)", i)
+	}
+	e, err := ParseExpression(sb.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := CompileProgram(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	constants, variables, scratch := p.RegisterCounts()
+	if constants != 1000 || variables != 1 || scratch > 3 {
+		t.Errorf("Got %d constants, %d variables and %d scratch registers, wanted 1000, 1 and at most 3", constants, variables, scratch)
+	}
+	unoptimized, _ := CompileProgram(e, WithOptimization(false))
+	if _, _, scratch := unoptimized.RegisterCounts(); scratch != 3000 {
+		t.Errorf("Without optimization there should be a register for each of the 3000 results, got %d", scratch)
+	}
+	vars := map[string]float64{"x": 0.1}
+	if res, want := p.Evaluate(vars), unoptimized.Evaluate(vars); res != want {
+		t.Errorf("Reusing registers changed the result from %g to %g", want, res)
+	}
+	if res, want := JitCompileExpression(e)(vars), unoptimized.Evaluate(vars); res != want {
+		t.Errorf("Reusing registers changed the jit compiled result from %g to %g", want, res)
+	}
+	out := make([]float64, 3)
+	if err := p.EvalBatch(map[string][]float64{"x": {0.1, 0.1, 0.1}}, out); err != nil || out[2] != unoptimized.Evaluate(vars) {
+		t.Errorf("Reusing registers changed the batch result from %g to %g", unoptimized.Evaluate(vars), out[2])
+	}
+}
+
+func TestProgramValidation(t *testing.T) {
+	max, _ := defaultFunctions.Lookup("max")
+	atan2, _ := defaultFunctions.Lookup("atan2")
+	tests := []struct {
+		name  string
+		build func(mm *MemoryManager) int
+	}{
+		{"unknown operation", func(mm *MemoryManager) int {
+			a := mm.AddConstant(1)
+			r := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: 99, A: a, Dst: r})
+			return r
+		}},
+		{"register out of range", func(mm *MemoryManager) int {
+			r := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: AddBytecode, A: 0, B: 5, Dst: r})
+			return r
+		}},
+		{"read before write", func(mm *MemoryManager) int {
+			a := mm.AddConstant(1)
+			r1 := mm.GetResultSpace()
+			r2 := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: SinBytecode, A: r2, Dst: r1})
+			mm.AddInstruction(Instruction{Op: SinBytecode, A: a, Dst: r2})
+			return r1
+		}},
+		{"writes a variable", func(mm *MemoryManager) int {
+			x := mm.AddVariable("x")
+			mm.AddInstruction(Instruction{Op: NegBytecode, A: x, Dst: x})
+			return x
+		}},
+		{"unused operand", func(mm *MemoryManager) int {
+			a := mm.AddConstant(1)
+			b := mm.AddConstant(2)
+			r := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: CosBytecode, A: a, B: b, Dst: r})
+			return r
+		}},
+		{"missing call", func(mm *MemoryManager) int {
+			r := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: CallBytecode, A: 0, Dst: r})
+			return r
+		}},
+		{"wrong argument count", func(mm *MemoryManager) int {
+			a := mm.AddConstant(1)
+			c := mm.AddCall(atan2, []int{a})
+			r := mm.GetResultSpace()
+			mm.AddInstruction(Instruction{Op: CallBytecode, A: c, Dst: r})
+			return r
+		}},
+		{"result out of range", func(mm *MemoryManager) int {
+			mm.AddConstant(1)
+			return 3
+		}},
+	}
+	for _, test := range tests {
+		mm := NewMemoryManager()
+		result := test.build(&mm)
+		if _, err := NewProgram(&mm, result); err == nil {
+			t.Errorf("%s: malformed program was not rejected", test.name)
+		}
+	}
+
+	//A well formed program made by hand
+	mm := NewMemoryManager()
+	a := mm.AddConstant(2)
+	x := mm.AddVariable("x")
+	c := mm.AddCall(max, []int{a, x})
+	r := mm.GetResultSpace()
+	mm.AddInstruction(Instruction{Op: CallBytecode, A: c, Dst: r})
+	p, err := NewProgram(&mm, r)
+	if err != nil {
+		t.Fatalf("Well formed program was rejected: %v", err)
+	}
+	if res := p.Evaluate(map[string]float64{"x": 5}); res != 5 {
+		t.Errorf("max(2, x) with x = 5 gave %g", res)
+	}
+}
+
 func TestCompile6(t *testing.T) {
 	expr := "sin(y/b)" //Something about this expression is cringe
 	e, err := ParseExpression(expr)
@@ -241,6 +695,831 @@ func TestEvaluateN(t *testing.T) {
 	}
 }
 
+func TestUnaryN(t *testing.T) {
+	tests := []QnA{
+		{"-x", 2},
+		{"+x", -2},
+		{"2*-3", -6},
+		{"-2*3", -6},
+		{"-x^2", -4},
+		{"2^-1", 0.5},
+		{"-(x+1)", 1},
+		{"3--x", 1},
+		{"-sin(0)", 0},
+		{"(-x)", 2},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i].q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", tests[i].q, err)
+			continue
+		}
+		vars := map[string]float64{"x": -2}
+		if e.Evaluate(vars) != tests[i].a {
+			t.Errorf("%s should = %g but evaluated to %g. Parsed to %s", tests[i].q, tests[i].a, e.Evaluate(vars), e.String())
+		}
+		if res := CompileExpression(e)(vars); res != tests[i].a {
+			t.Errorf("%s should = %g but compiled version evaluated to %g", tests[i].q, tests[i].a, res)
+		}
+	}
+}
+
+func TestStringRoundTrip(t *testing.T) {
+	tests := [][2]string{
+		{"(-x)^2", "((-x) ^ 2)"},
+		{"(-2)^2", "((-2) ^ 2)"},
+		{"-x^2", "-(x ^ 2)"},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e.String() != tests[i][1] {
+			t.Errorf("%s printed as %s, wanted %s", tests[i][0], e.String(), tests[i][1])
+		}
+	}
+	if e, _ := ParseExpression("(-x)^2"); e.Latex() != "\\left(-x\\right)^{2}" {
+		t.Errorf("(-x)^2 printed in latex as %s", e.Latex())
+	}
+
+	//Printing random expressions and parsing them again gives the same value
+	rng := rand.New(rand.NewSource(1))
+	var random func(depth int) Expression
+	random = func(depth int) Expression {
+		if depth == 0 || rng.Intn(4) == 0 {
+			if rng.Intn(2) == 0 {
+				return Variable{"x"}
+			}
+			return Constant{float64(rng.Intn(11) - 5)}
+		}
+		a, b := random(depth-1), random(depth-1)
+		switch rng.Intn(7) {
+		case 0:
+			return Adder{a, b}
+		case 1:
+			return Subtractor{a, b}
+		case 2:
+			return Multiplier{a, b}
+		case 3:
+			return Divider{a, b}
+		case 4:
+			return Powerer{a, b}
+		case 5:
+			return Negator{a}
+		}
+		return Siner{a}
+	}
+	vars := map[string]float64{"x": 3}
+	for i := 0; i < 5000; i++ {
+		e := random(4)
+		parsed, err := ParseExpression(e.String())
+		if err != nil {
+			t.Fatalf("%s did not parse: %v", e, err)
+		}
+		a, b := e.Evaluate(vars), parsed.Evaluate(vars)
+		if a != b && !(math.IsNaN(a) && math.IsNaN(b)) {
+			t.Errorf("%s = %g but parsed back as %s = %g", e, a, parsed, b)
+		}
+	}
+}
+
+func TestUnaryDerivative(t *testing.T) {
+	e, err := ParseExpression("-x^2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := e.Derive("x")
+	if res := d.Evaluate(map[string]float64{"x": 3}); res != -6 {
+		t.Errorf("Wanted -6, got %g. Derivative is %s", res, d.String())
+	}
+	if e.String() != "-(x ^ 2)" {
+		t.Errorf("Wanted %s, got %s", "-(x ^ 2)", e.String())
+	}
+}
+
+func TestImplicitMultiplicationN(t *testing.T) {
+	tests := []QnA{
+		{"2x", -4},
+		{"3(x+1)", -3},
+		{"(x)(x)", 4},
+		{"2sin(0)", 0},
+		{"2cos(0)", 2},
+		{"x(x+3)", -2},
+		{"2x^2", 8},
+		{"-2x", 4},
+		{"1/2x", -1},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i].q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", tests[i].q, err)
+			continue
+		}
+		vars := map[string]float64{"x": -2}
+		if e.Evaluate(vars) != tests[i].a {
+			t.Errorf("%s should = %g but evaluated to %g. Parsed to %s", tests[i].q, tests[i].a, e.Evaluate(vars), e.String())
+		}
+	}
+}
+
+func TestImplicitMultiplicationDisabled(t *testing.T) {
+	for _, expr := range []string{"2x", "3(x+1)", "(a)(b)", "2sin(x)"} {
+		if _, err := ParseExpression(expr, WithImplicitMultiplication(false)); err == nil {
+			t.Errorf("%s should not parse without implicit multiplication", expr)
+		}
+	}
+	if _, err := ParseExpression("2*x", WithImplicitMultiplication(false)); err != nil {
+		t.Errorf("2*x should parse without implicit multiplication: %v", err)
+	}
+}
+
+type ParseErrorQnA struct {
+	q      string
+	kind   ParseErrorKind
+	offset int
+}
+
+func TestParseErrorN(t *testing.T) {
+	tests := []ParseErrorQnA{
+		{"2 + % 3", UnknownCharacterError, 4},
+		{"(2+3", UnbalancedParenError, 0},
+		{"2+3)", UnbalancedParenError, 3},
+		{"2+", MissingOperandError, 1},
+		{"*2", MissingOperandError, 0},
+		{"", MissingOperandError, 0},
+		{"1.2.3+x", InvalidNumberError, 0},
+		//A function without brackets fails the same way at the end of the input
+		{"sin x", MissingOperandError, 0},
+		{"sin", MissingOperandError, 0},
+		{"atan2", MissingOperandError, 0},
+		{"x+sin", MissingOperandError, 2},
+		//A point after a function name starts a number rather than continuing the name
+		{"sin.", MissingOperandError, 0},
+		{"max.5", MissingOperandError, 0},
+	}
+	for _, test := range tests {
+		_, err := ParseExpression(test.q)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%q should have failed with a ParseError but got %v", test.q, err)
+			continue
+		}
+		if pe.Kind != test.kind || pe.Offset != test.offset {
+			t.Errorf("%q should have failed with %s at %d but got %s at %d", test.q, test.kind, test.offset, pe.Kind, pe.Offset)
+		}
+	}
+}
+
+func TestParseErrorStrict(t *testing.T) {
+	tests := []ParseErrorQnA{
+		{"2 x", MissingOperatorError, 2},
+		{"foo(x)", UnknownFunctionError, 0},
+	}
+	for _, test := range tests {
+		_, err := ParseExpression(test.q, WithImplicitMultiplication(false))
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%q should have failed with a ParseError but got %v", test.q, err)
+			continue
+		}
+		if pe.Kind != test.kind || pe.Offset != test.offset {
+			t.Errorf("%q should have failed with %s at %d but got %s at %d", test.q, test.kind, test.offset, pe.Kind, pe.Offset)
+		}
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	_, err := ParseExpression("2 + % 3")
+	var pe *ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf("expected a ParseError, got %v", err)
+	}
+	want := "2 + % 3\n    ^"
+	if pe.Caret() != want {
+		t.Errorf("Wanted\n%s\ngot\n%s", want, pe.Caret())
+	}
+}
+
+func TestFunctionCallN(t *testing.T) {
+	tests := []QnA{
+		{"max(1, x, 3)", 3},
+		{"min(1, x, 3)", -2},
+		{"max(x)", -2},
+		{"atan2(1, 1)", math.Atan2(1, 1)},
+		{"log(8, 2)", 3},
+		{"clamp(x, 0, 1)", 0},
+		{"clamp(5, 0, 1)", 1},
+		{"2*max(x+1, -x)-1", 3},
+		{"max(min(1, 2), sin(0), -(x))", 2},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i].q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", tests[i].q, err)
+			continue
+		}
+		vars := map[string]float64{"x": -2}
+		if e.Evaluate(vars) != tests[i].a {
+			t.Errorf("%s should = %g but evaluated to %g. Parsed to %s", tests[i].q, tests[i].a, e.Evaluate(vars), e.String())
+		}
+		if res := CompileExpression(e)(vars); res != tests[i].a {
+			t.Errorf("%s should = %g but compiled version evaluated to %g", tests[i].q, tests[i].a, res)
+		}
+	}
+}
+
+func TestFunctionCallErrors(t *testing.T) {
+	tests := []ParseErrorQnA{
+		{"atan2(1)", WrongArgumentCountError, 0},
+		{"clamp(x, 0)", WrongArgumentCountError, 0},
+		{"max()", WrongArgumentCountError, 0},
+		{"max(1,,2)", MissingOperandError, 6},
+		{"max(1,)", MissingOperandError, 6},
+		{"1, 2", MisplacedSeparatorError, 1},
+		{"(1, 2)", MisplacedSeparatorError, 2},
+		{",", MissingOperandError, 0},
+		{",x", MissingOperandError, 0},
+	}
+	for _, test := range tests {
+		_, err := ParseExpression(test.q)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("%q should have failed with a ParseError but got %v", test.q, err)
+			continue
+		}
+		if pe.Kind != test.kind || pe.Offset != test.offset {
+			t.Errorf("%q should have failed with %s at %d but got %s at %d", test.q, test.kind, test.offset, pe.Kind, pe.Offset)
+		}
+	}
+}
+
+func TestFunctionCallDerivative(t *testing.T) {
+	e, err := ParseExpression("atan2(x, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := e.Derive("x")
+	//d/dx atan(x/2) = 2/(4+x^2)
+	if res := d.Evaluate(map[string]float64{"x": 2}); res != 0.25 {
+		t.Errorf("Wanted 0.25, got %g. Derivative is %s", res, d.String())
+	}
+
+	//max, min and clamp follow whichever argument they give
+	piecewise := []struct {
+		q    string
+		x, a float64
+	}{
+		{"max(x, 2)", 3, 1},
+		{"max(x, 2)", 1, 0},
+		{"max(x, 2x, 1)", 3, 2},
+		{"min(x, 2x, 1)", -3, 2},
+		{"min(x^2, 5)", 2, 4},
+		{"clamp(x^2, 0, 4)", 1, 2},
+		{"clamp(x^2, 0, 4)", 3, 0},
+		{"clamp(2, x, 5)", 3, 1},
+		{"clamp(2, x, 5)", 1, 0},
+		{"clamp(9, 1, x)", 3, 1},
+	}
+	for _, test := range piecewise {
+		e, err := ParseExpression(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := e.Derive("x")
+		if res := d.Evaluate(map[string]float64{"x": test.x}); res != test.a {
+			t.Errorf("Derivative of %s at %g should be %g but was %g. Derivative is %s", test.q, test.x, test.a, res, d.String())
+		}
+	}
+	//Where the arguments are equal there is no derivative
+	e, err = ParseExpression("max(x, 2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := e.Derive("x").Evaluate(map[string]float64{"x": 2}); !math.IsNaN(res) {
+		t.Errorf("Derivative of max(x, 2) at 2 should be NaN but was %g", res)
+	}
+}
+
+func TestFunctionRegistry(t *testing.T) {
+	r := NewFunctionRegistry()
+	err := r.Register(Function{
+		Name:  "sigmoid",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return 1 / (1 + math.Exp(-args[0])) },
+		Partial: func(args []Expression, i int) Expression {
+			//sigmoid(a)*(1-sigmoid(a))
+			f, _ := r.Lookup("sigmoid")
+			s := FunctionCall{Func: f, Args: args}
+			return Multiplier{A: s, B: Subtractor{A: Constant{1}, B: s}}
+		},
+		Latex: "\\sigma\\left(%s\\right)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Register(Function{
+		Name:  "relu",
+		Arity: 1,
+		Eval:  func(args []float64) float64 { return math.Max(0, args[0]) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := ParseExpression("2*sigmoid(x) + relu(x-1)", WithFunctions(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]float64{"x": 0}
+	if res := e.Evaluate(vars); res != 1 {
+		t.Errorf("Wanted 1, got %g. Parsed to %s", res, e.String())
+	}
+	if res := CompileExpression(e)(vars); res != 1 {
+		t.Errorf("Wanted compiled result 1, got %g", res)
+	}
+	//relu was registered without a derivative
+	if res := e.Derive("x").Evaluate(vars); !math.IsNaN(res) {
+		t.Errorf("Wanted derivative NaN, got %g. Derivative is %s", res, e.Derive("x").String())
+	}
+	e2, err := ParseExpression("2*sigmoid(x)", WithFunctions(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := e2.Derive("x").Evaluate(vars); res != 0.5 {
+		t.Errorf("Wanted derivative 0.5, got %g. Derivative is %s", res, e2.Derive("x").String())
+	}
+	if e.Latex() != "2 \\times \\sigma\\left(x\\right) + \\operatorname{relu}\\left(x - 1\\right)" {
+		t.Errorf("Unexpected latex %s", e.Latex())
+	}
+	if _, err := ParseExpression("sigmoid(x, 1)", WithFunctions(r)); err == nil {
+		t.Errorf("sigmoid(x, 1) should have the wrong number of arguments")
+	}
+
+	//The template of a variadic function is given all of its arguments at once
+	err = r.Register(Function{
+		Name:  "total",
+		Arity: Variadic,
+		Eval: func(args []float64) float64 {
+			sum := 0.0
+			for _, a := range args {
+				sum += a
+			}
+			return sum
+		},
+		Latex: "\\Sigma\\left(%s\\right)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	e3, err := ParseExpression("max(x, 1, y) + total(x, 2)", WithFunctions(r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e3.Latex() != "\\max\\left(x, 1, y\\right) + \\Sigma\\left(x, 2\\right)" {
+		t.Errorf("Unexpected latex %s", e3.Latex())
+	}
+
+	//A nil registry is the built in functions
+	e4, err := ParseExpression("max(x, 2) + sin(x)", WithFunctions(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := e4.Evaluate(vars); res != 2 {
+		t.Errorf("max(x, 2) + sin(x) with a nil registry should = 2 but evaluated to %g. Parsed to %s", res, e4.String())
+	}
+}
+
+func TestFunctionRegistryErrors(t *testing.T) {
+	r := NewFunctionRegistry()
+	identity := func(args []float64) float64 { return args[0] }
+	bad := []Function{
+		{Name: "", Arity: 1, Eval: identity},
+		{Name: "2f", Arity: 1, Eval: identity},
+		{Name: "f-g", Arity: 1, Eval: identity},
+		{Name: "f", Arity: -3, Eval: identity},
+		{Name: "f", Arity: 1},
+	}
+	for _, f := range bad {
+		if err := r.Register(f); err == nil {
+			t.Errorf("Registering %+v should have failed", f)
+		}
+	}
+}
+
+func TestElementaryN(t *testing.T) {
+	x := 0.3
+	tests := []QnA{
+		{"tan(x)", math.Tan(x)},
+		{"exp(x)", math.Exp(x)},
+		{"sqrt(x)", math.Sqrt(x)},
+		{"abs(-x)", x},
+		{"log10(x)", math.Log10(x)},
+		{"log2(x)", math.Log2(x)},
+		{"asin(x)", math.Asin(x)},
+		{"acos(x)", math.Acos(x)},
+		{"atan(x)", math.Atan(x)},
+		{"sinh(x)", math.Sinh(x)},
+		{"cosh(x)", math.Cosh(x)},
+		{"tanh(x)", math.Tanh(x)},
+		{"2exp(x^2)", 2 * math.Exp(x*x)},
+	}
+	h := 1e-6
+	for i := range tests {
+		e, err := ParseExpression(tests[i].q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", tests[i].q, err)
+			continue
+		}
+		vars := map[string]float64{"x": x}
+		if e.Evaluate(vars) != tests[i].a {
+			t.Errorf("%s should = %g but evaluated to %g. Parsed to %s", tests[i].q, tests[i].a, e.Evaluate(vars), e.String())
+		}
+		if res := CompileExpression(e)(vars); res != tests[i].a {
+			t.Errorf("%s should = %g but compiled version evaluated to %g", tests[i].q, tests[i].a, res)
+		}
+		//Compare the derivative with a central difference
+		d := e.Derive("x").Evaluate(vars)
+		approx := (e.Evaluate(map[string]float64{"x": x + h}) - e.Evaluate(map[string]float64{"x": x - h})) / (2 * h)
+		if math.Abs(d-approx) > 1e-5 {
+			t.Errorf("Derivative of %s should be about %g but was %g. Derivative is %s", tests[i].q, approx, d, e.Derive("x").String())
+		}
+	}
+}
+
+func TestElementarySimplify(t *testing.T) {
+	e, err := ParseExpression("sqrt(4) * x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Simplify().String() != "(2 * x)" {
+		t.Errorf("Wanted %s, got %s", "(2 * x)", e.Simplify().String())
+	}
+	if e.Latex() != "\\sqrt{4} \\times x" {
+		t.Errorf("Wanted %s, got %s", "\\sqrt{4} \\times x", e.Latex())
+	}
+}
+
+func TestNamedConstants(t *testing.T) {
+	tests := []QnA{
+		{"pi", math.Pi},
+		{"2pi", 2 * math.Pi},
+		{"tau/2", math.Pi},
+		{"e^x", math.Exp(-2)},
+		{"phi", math.Phi},
+		{"-inf", math.Inf(-1)},
+		{"cos(pi)", -1},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i].q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", tests[i].q, err)
+			continue
+		}
+		vars := map[string]float64{"x": -2, "pi": 3}
+		if e.Evaluate(vars) != tests[i].a {
+			t.Errorf("%s should = %g but evaluated to %g. Parsed to %s", tests[i].q, tests[i].a, e.Evaluate(vars), e.String())
+		}
+		if res := CompileExpression(e)(vars); res != tests[i].a {
+			t.Errorf("%s should = %g but compiled version evaluated to %g", tests[i].q, tests[i].a, res)
+		}
+	}
+
+	e, err := ParseExpression("pi*x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Latex() != "\\pi \\times x" {
+		t.Errorf("Wanted %s, got %s", "\\pi \\times x", e.Latex())
+	}
+	if d := e.Derive("pi").Simplify().String(); d != "0" {
+		t.Errorf("Derivative with respect to pi should be 0 but was %s", d)
+	}
+	if s := e.Simplify().String(); s != "(pi * x)" {
+		t.Errorf("pi*x should stay as %s but simplified to %s", "(pi * x)", s)
+	}
+	e, err = ParseExpression("2*pi - pi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := e.Simplify(); s.Evaluate(nil) != math.Pi || s.String() != fmt.Sprint(math.Pi) {
+		t.Errorf("2*pi - pi should fold to %g but simplified to %s", math.Pi, s.String())
+	}
+
+	//Numbers folded to infinity or NaN print as something that parses back to them
+	folded := []struct{ q, str, latex string }{
+		{"1/0", "inf", "\\infty"},
+		{"x - 2*inf", "(x - inf)", "x - \\infty"},
+		{"(0-2*inf)^x", "((-inf) ^ x)", "\\left(-\\infty\\right)^{x}"},
+		{"inf-inf", "(0 / 0)", "NaN"},
+	}
+	for _, test := range folded {
+		e, err := ParseExpression(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := e.Simplify()
+		if s.String() != test.str || s.Latex() != test.latex {
+			t.Errorf("%s simplified to %s (%s), wanted %s (%s)", test.q, s.String(), s.Latex(), test.str, test.latex)
+		}
+		back, err := ParseExpression(s.String())
+		if err != nil {
+			t.Errorf("%s simplified to %s which does not parse: %v", test.q, s.String(), err)
+			continue
+		}
+		vars := map[string]float64{"x": 2}
+		if a, b := s.Evaluate(vars), back.Evaluate(vars); !sameFloat(a, b) {
+			t.Errorf("%s simplified to %s = %g which parses back to %g", test.q, s.String(), a, b)
+		}
+	}
+
+	//A value given for e is ignored as e is the constant unless it is parsed as a variable
+	e, err = ParseExpression("e*x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vars := map[string]float64{"e": 2, "x": 3}
+	if res := e.Evaluate(vars); res != math.E*3 {
+		t.Errorf("e*x should = %g but evaluated to %g", math.E*3, res)
+	}
+	if d := e.Derive("e").String(); d != "0" {
+		t.Errorf("Derivative of e*x with respect to e should be 0 but was %s", d)
+	}
+	e, err = ParseExpression("e*x", WithoutConstants("e"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, d := e.Evaluate(vars), e.Derive("e").String(); res != 6 || d != "x" {
+		t.Errorf("e*x with e as a variable should = 6 with derivative x but was %g with derivative %s", res, d)
+	}
+}
+
+func TestNamedConstantOptions(t *testing.T) {
+	e, err := ParseExpression("e*g", WithoutConstants("e"), WithConstants(map[string]float64{"g": 9.81}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := e.Evaluate(map[string]float64{"e": 2}); res != 2*9.81 {
+		t.Errorf("Wanted %g, got %g. Parsed to %s", 2*9.81, res, e.String())
+	}
+	e, err = ParseExpression("pi", WithConstants(map[string]float64{"pi": 3}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := e.Evaluate(nil); res != 3 {
+		t.Errorf("Wanted overridden pi to be 3, got %g", res)
+	}
+	//Options must not change the defaults
+	e, err = ParseExpression("pi+e")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pi, euler := math.Pi, math.E
+	if res := e.Evaluate(nil); res != pi+euler {
+		t.Errorf("Wanted %g, got %g", pi+euler, res)
+	}
+}
+
+func TestVariables(t *testing.T) {
+	e, err := ParseExpression("y*sin(x) + max(b, a, pi) - y^x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := fmt.Sprint(Variables(e))
+	if got != "[a b x y]" {
+		t.Errorf("Wanted %s, got %s", "[a b x y]", got)
+	}
+}
+
+func TestEvaluateStrict(t *testing.T) {
+	e, err := ParseExpression("a*x + b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiled := CompileExpressionStrict(e)
+	vars := map[string]float64{"x": 2}
+
+	_, err = EvaluateStrict(e, vars)
+	var unbound *UnboundVariableError
+	if !errors.As(err, &unbound) || fmt.Sprint(unbound.Symbols) != "[a b]" {
+		t.Errorf("Wanted unbound a and b, got %v", err)
+	}
+	_, err = compiled(vars)
+	if !errors.As(err, &unbound) || fmt.Sprint(unbound.Symbols) != "[a b]" {
+		t.Errorf("Wanted compiled version to report unbound a and b, got %v", err)
+	}
+
+	vars["a"] = 3
+	vars["b"] = 1
+	if res, err := EvaluateStrict(e, vars); err != nil || res != 7 {
+		t.Errorf("Wanted 7, got %g, %v", res, err)
+	}
+	if res, err := compiled(vars); err != nil || res != 7 {
+		t.Errorf("Wanted compiled version to give 7, got %g, %v", res, err)
+	}
+}
+
+func TestSubstitute(t *testing.T) {
+	e, err := ParseExpression("x^2 + y^2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	polar := Substitute(e, map[string]Expression{
+		"x": Multiplier{A: Variable{"r"}, B: Coser{Variable{"t"}}},
+		"y": Multiplier{A: Variable{"r"}, B: Siner{Variable{"t"}}},
+	})
+	if got := fmt.Sprint(Variables(polar)); got != "[r t]" {
+		t.Errorf("Wanted variables [r t], got %s from %s", got, polar.String())
+	}
+	res := polar.Evaluate(map[string]float64{"r": 2, "t": 0.7})
+	if math.Abs(res-4) > 1e-12 {
+		t.Errorf("Wanted r^2 = 4, got %g from %s", res, polar.String())
+	}
+
+	//Replacements are not substituted into again
+	swapped := Substitute(e, map[string]Expression{"x": Variable{"y"}, "y": Variable{"x"}})
+	if swapped.String() != "((y ^ 2) + (x ^ 2))" {
+		t.Errorf("Wanted %s, got %s", "((y ^ 2) + (x ^ 2))", swapped.String())
+	}
+}
+
+func TestFreeVariables(t *testing.T) {
+	e, err := ParseExpression("a*exp(-k*t) + atan2(y, x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	free := FreeVariables(e)
+	for _, name := range []string{"a", "k", "t", "x", "y"} {
+		if !free[name] {
+			t.Errorf("%s should be free in %s", name, e.String())
+		}
+	}
+	if len(free) != 5 {
+		t.Errorf("Wanted 5 free variables, got %v", free)
+	}
+}
+
+func TestPartialEvaluate(t *testing.T) {
+	e, err := ParseExpression("a*x + b*c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	curried := PartialEvaluate(e, map[string]float64{"a": 2, "b": 3, "c": 0})
+	if curried.String() != "(2 * x)" {
+		t.Errorf("Wanted %s, got %s", "(2 * x)", curried.String())
+	}
+	if res := curried.Evaluate(map[string]float64{"x": 5}); res != 10 {
+		t.Errorf("Wanted 10, got %g", res)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	e, err := ParseExpression("sin(x)*x + max(1, y, x^2)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nodes := 0
+	uses := map[string]int{}
+	Walk(e, func(e Expression) bool {
+		nodes++
+		if v, ok := e.(Variable); ok {
+			uses[v.Symbol]++
+		}
+		return true
+	})
+	if nodes != 11 {
+		t.Errorf("Wanted 11 nodes, got %d", nodes)
+	}
+	if uses["x"] != 3 || uses["y"] != 1 {
+		t.Errorf("Wanted x used 3 times and y once, got %v", uses)
+	}
+
+	//Returning false skips the children
+	skipped := 0
+	Walk(e, func(e Expression) bool {
+		skipped++
+		_, isCall := e.(FunctionCall)
+		return !isCall
+	})
+	if skipped != 6 {
+		t.Errorf("Wanted 6 nodes outside of max, got %d", skipped)
+	}
+}
+
+func TestRewrite(t *testing.T) {
+	e, err := ParseExpression("2*x + cos(x)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Turn every multiplication into an addition
+	r := Rewrite(e, func(e Expression) Expression {
+		if m, ok := e.(Multiplier); ok {
+			return Adder{A: m.A, B: m.B}
+		}
+		return e
+	})
+	if r.String() != "((2 + x) + cos(x))" {
+		t.Errorf("Wanted %s, got %s", "((2 + x) + cos(x))", r.String())
+	}
+	if e.String() != "((2 * x) + cos(x))" {
+		t.Errorf("Rewrite should not change the original but it is now %s", e.String())
+	}
+
+	//Changing the children of a call does not change the call
+	call, err := ParseExpression("max(x, y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	call.Children()[0] = Constant{1}
+	if call.String() != "max(x, y)" {
+		t.Errorf("Changing the children of max(x, y) changed it to %s", call.String())
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("WithChildren with the wrong number of children should panic")
+		}
+	}()
+	Adder{A: Constant{1}, B: Constant{2}}.WithChildren([]Expression{Constant{1}})
+}
+
+type EqualQnA struct {
+	a, b        string
+	equal       bool
+	commutative bool
+}
+
+func TestEqualN(t *testing.T) {
+	tests := []EqualQnA{
+		{"x+1", "x+1", true, true},
+		{"x+1", "1+x", false, true},
+		{"a+b+c", "c+(b+a)", false, true},
+		{"a*b*c", "b*(c*a)", false, true},
+		{"a-b", "b-a", false, false},
+		{"a/b", "b/a", false, false},
+		{"max(x, 1)", "max(x, 1)", true, true},
+		{"max(x, 1)", "min(x, 1)", false, false},
+		{"max(x, 1)", "max(x, 1, 2)", false, false},
+		{"x+x+y", "x+y+y", false, false},
+		{"sin(x)", "cos(x)", false, false},
+		{"pi", "3.141592653589793", false, false},
+		{"2*sin(x*y)", "sin(y*x)*2", false, true},
+	}
+	for _, test := range tests {
+		a, err := ParseExpression(test.a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ParseExpression(test.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Equal(a, b) != test.equal {
+			t.Errorf("Equal(%s, %s) should be %v", test.a, test.b, test.equal)
+		}
+		if EqualCommutative(a, b) != test.commutative {
+			t.Errorf("EqualCommutative(%s, %s) should be %v", test.a, test.b, test.commutative)
+		}
+		if test.equal && Hash(a) != Hash(b) {
+			t.Errorf("Hash(%s) and Hash(%s) should be the same", test.a, test.b)
+		}
+		if test.commutative && HashCommutative(a) != HashCommutative(b) {
+			t.Errorf("HashCommutative(%s) and HashCommutative(%s) should be the same", test.a, test.b)
+		}
+		if !test.equal && Hash(a) == Hash(b) {
+			t.Errorf("Hash(%s) and Hash(%s) should probably be different", test.a, test.b)
+		}
+	}
+}
+
+func TestEqualSpecialValues(t *testing.T) {
+	nan := Constant{math.NaN()}
+	if !Equal(nan, nan) || Hash(nan) != Hash(Constant{math.NaN()}) {
+		t.Errorf("NaN constants should be equal to themselves")
+	}
+	if !Equal(Constant{0}, Constant{math.Copysign(0, -1)}) || Hash(Constant{0}) != Hash(Constant{math.Copysign(0, -1)}) {
+		t.Errorf("0 and -0 should be equal")
+	}
+	//Structurally equal function calls can be used where comparable types are needed
+	e, err := ParseExpression("max(x, 1) * max(x, 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := e.(Multiplier)
+	if !Equal(m.A, m.B) {
+		t.Errorf("%s and %s should be equal", m.A, m.B)
+	}
+
+	//The hash must not change between runs or versions so it can be stored
+	e, err = ParseExpression("2*x+sin(y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Hash(e) != 0x3478dc51806fee9 {
+		t.Errorf("Hash of %s changed to %#x", e, Hash(e))
+	}
+}
+
 type IntegrateQnA struct {
 	exp        string
 	wrt        string
@@ -257,7 +1536,7 @@ func TestIntegrateN(t *testing.T) {
 		{"x^2", "x", 0, 4, 64.0 / 3.0, 10000},
 		{"sin(x)", "x", 0, 4, 1.6536, 100},
 		//https://tutorial.math.lamar.edu/classes/calcii/surfacearea.aspx
-		{"2*3.14159*((9-x^2)^.5)*(3/((9-x^2)^.5)", "x", -2, 2, 24 * math.Pi, 100},
+		{"2*3.14159*((9-x^2)^.5)*(3/((9-x^2)^.5))", "x", -2, 2, 24 * math.Pi, 100},
 	}
 	//*
 	for i := range tests {
@@ -278,7 +1557,7 @@ func TestIntegrateN(t *testing.T) {
 
 func TestParseToPostfix(t *testing.T) {
 	expr := "3*4+2"
-	tokens, err := tokenize(expr)
+	tokens, err := tokenize(expr, NewFunctionRegistry())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -295,18 +1574,22 @@ func TestParseToPostfix(t *testing.T) {
 		{
 			Type:  NumberType,
 			Value: "4",
+			Pos:   2,
 		},
 		{
 			Type:  OperatorType,
 			Value: "*",
+			Pos:   1,
 		},
 		{
 			Type:  NumberType,
 			Value: "2",
+			Pos:   4,
 		},
 		{
 			Type:  OperatorType,
 			Value: "+",
+			Pos:   3,
 		},
 	}
 	for i := 0; i < len(pf); i++ {
@@ -387,12 +1670,12 @@ func TestStack(t *testing.T) {
 }
 
 func TestTokenize(t *testing.T) {
-	got, err := tokenize("aa*x^2-bx+c")
+	got, err := tokenize("aa*x^2-bx+c", NewFunctionRegistry())
 	fmt.Println(got, err)
 }
 
 func TestTokenize2(t *testing.T) {
-	ts, err := tokenize("2+3*cos(3)")
+	ts, err := tokenize("2+3*cos(3)", NewFunctionRegistry())
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -475,6 +1758,18 @@ func TestSimplifyN(t *testing.T) {
 		{"x/x*x", "x"},
 		{"x*x*x/x", "(x ^ 2)"},
 		{"x/x*x/x", "1"},
+		{"x^3/x", "(x ^ 2)"},
+		{"x*y/x", "y"},
+		{"x*2", "(2 * x)"},
+		{"3*x*2", "(6 * x)"},
+		{"x/2", "(x / 2)"},
+		{"2*x/4", "(x / 2)"},
+		{"y/(x/y)", "((y ^ 2) / x)"},
+		{"x/(y*x^2)", "(1 / (x * y))"},
+		{"x^n*x", "(x ^ (n + 1))"},
+		{"-x*x", "-(x ^ 2)"},
+		{"(x+1)^2/(x+1)", "(x + 1)"},
+		{"pi*x*pi", "((pi ^ 2) * x)"},
 	}
 	//{"x/x", "1"},
 
@@ -490,6 +1785,243 @@ func TestSimplifyN(t *testing.T) {
 	}
 }
 
+func TestSimplifySum(t *testing.T) {
+	tests := [][2]string{
+		{"3*x + 2*x", "(5 * x)"},
+		{"x - x", "0"},
+		{"x + 1 + x + 2", "((2 * x) + 3)"},
+		{"x + y", "(x + y)"},
+		{"y + x", "(x + y)"},
+		{"x - (y - x)", "((2 * x) - y)"},
+		{"2 + x", "(x + 2)"},
+		{"9 - x^2", "(9 - (x ^ 2))"},
+		{"-x - y", "(-x - y)"},
+		{"x - (x + y)", "-y"},
+		{"y - 2*y", "-y"},
+		{"0 - x*y", "-(x * y)"},
+		{"0 - -x", "x"},
+		{"x*3 - 3*x + 5", "5"},
+		{"pi + x + pi", fmt.Sprintf("(x + %v)", 2*math.Pi)},
+		{"pi + x", "(pi + x)"},
+		{"x + pi - pi", "x"},
+		{"-(x - 4) + x", "4"},
+		{"2*sin(x) - sin(x)*2 + cos(x)", "cos(x)"},
+	}
+	for i := range tests {
+		e, err := ParseExpression(tests[i][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := e.Simplify()
+		if s.String() != tests[i][1] {
+			t.Errorf("%s should simplify to %s but simplified to %s", tests[i][0], tests[i][1], s.String())
+		}
+		if s.Simplify().String() != s.String() {
+			t.Errorf("%s simplified to %s and then to %s", tests[i][0], s.String(), s.Simplify().String())
+		}
+		if a, b := e.Evaluate(map[string]float64{"x": 1.5, "y": -2}), s.Evaluate(map[string]float64{"x": 1.5, "y": -2}); math.Abs(a-b) > 1e-12 {
+			t.Errorf("%s = %g but simplified to %s = %g", tests[i][0], a, s.String(), b)
+		}
+	}
+
+	e, err := ParseExpression("3*x + 2*x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, steps := SimplifyWithSteps(e); len(steps) != 1 || steps[0].Rule != "combine like terms" || steps[0].String() != "((3 * x) + (2 * x)) → (5 * x)" {
+		t.Errorf("3*x + 2*x was simplified with the steps %v", steps)
+	}
+}
+
+func TestSimplifier(t *testing.T) {
+	lnExp := Rule{
+		Name: "ln of exp",
+		Rewrite: func(e Expression) (Expression, bool) {
+			if l, ok := e.(NaturalLogger); ok {
+				if exp, ok := l.A.(Exponentiator); ok {
+					return exp.A, true
+				}
+			}
+			return e, false
+		},
+	}
+	tests := []struct {
+		q    string
+		opts []SimplifyOption
+		a    string
+	}{
+		{"(1*x)+(x*1)", nil, "(2 * x)"},
+		{"x*(x+1)", nil, "(x * (x + 1))"},
+		{"x*(x+1)", []SimplifyOption{WithRuleSets(BasicRules, ExpandRules)}, "((x ^ 2) + x)"},
+		{"(x+1)^2", []SimplifyOption{WithRuleSets(BasicRules, ExpandRules)}, "(((x ^ 2) + (2 * x)) + 1)"},
+		{"(x+y)*(x-y)", []SimplifyOption{WithRuleSets(BasicRules, ExpandRules)}, "((x ^ 2) - (y ^ 2))"},
+		{"(x+1)/x", []SimplifyOption{WithRuleSets(BasicRules, ExpandRules)}, "((1 / x) + 1)"},
+		{"x*y+x*z", []SimplifyOption{WithRuleSets(BasicRules, FactorRules)}, "(x * (y + z))"},
+		{"2*x^2+4*x", []SimplifyOption{WithRuleSets(BasicRules, FactorRules)}, "(2 * (x * (x + 2)))"},
+		{"3*sin(x)^2+3*cos(x)^2+y", []SimplifyOption{WithRuleSets(BasicRules, TrigRules)}, "(y + 3)"},
+		{"sin(-x)+cos(-2*x)", []SimplifyOption{WithRuleSets(BasicRules, TrigRules)}, "(cos((2 * x)) - sin(x))"},
+		{"sin(x)/cos(x)", []SimplifyOption{WithRuleSets(BasicRules, TrigRules)}, "tan(x)"},
+		{"x*1+ln(exp(y))", []SimplifyOption{WithRules(lnExp)}, "(x + y)"},
+		{"x*1+ln(exp(y))", []SimplifyOption{WithRuleSets(), WithRules(lnExp)}, "((x * 1) + y)"},
+	}
+	for _, test := range tests {
+		e, err := ParseExpression(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := SimplifyWith(e, test.opts...)
+		if s.String() != test.a {
+			t.Errorf("%s should simplify to %s but simplified to %s", test.q, test.a, s.String())
+		}
+		if a, b := e.Evaluate(map[string]float64{"x": 1.5, "y": -2, "z": 0.25}), s.Evaluate(map[string]float64{"x": 1.5, "y": -2, "z": 0.25}); math.Abs(a-b) > 1e-12 {
+			t.Errorf("%s = %g but simplified to %s = %g", test.q, a, s.String(), b)
+		}
+	}
+
+	//Rules that undo each other stop after the most iterations
+	swap := Rule{
+		Name: "swap sin and cos",
+		Rewrite: func(e Expression) (Expression, bool) {
+			switch v := e.(type) {
+			case Siner:
+				return Coser{v.A}, true
+			case Coser:
+				return Siner{v.A}, true
+			}
+			return e, false
+		},
+	}
+	rewrites := 0
+	s := NewSimplifier(WithRuleSets(), WithMaxIterations(3), WithTracer(TracerFunc(func(string, Expression, Expression) {
+		rewrites++
+	})))
+	s.AddRule(swap)
+	if r := s.Simplify(Siner{Variable{"x"}}); r.String() != "cos(x)" || rewrites != 3 {
+		t.Errorf("sin(x) became %s after %d rewrites, wanted cos(x) after 3", r, rewrites)
+	}
+
+	//A rule without a Rewrite function is refused when it is added rather than panicking while simplifying
+	noRewrite := Rule{Name: "no rewrite"}
+	adds := map[string]func(){
+		"WithRules":    func() { NewSimplifier(WithRules(noRewrite)) },
+		"WithRuleSets": func() { NewSimplifier(WithRuleSets(RuleSet{Name: "bad", Rules: []Rule{noRewrite}})) },
+		"AddRule":      func() { NewSimplifier().AddRule(noRewrite) },
+	}
+	for name, add := range adds {
+		func() {
+			defer func() {
+				if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), `"no rewrite"`) {
+					t.Errorf("%s with a rule without a Rewrite function should panic naming the rule, got %v", name, r)
+				}
+			}()
+			add()
+		}()
+	}
+}
+
+func TestSimplifyTracer(t *testing.T) {
+	tests := []struct {
+		q     string
+		rules []string
+		a     string
+	}{
+		{"x*1", []string{"multiply by one: (x * 1) -> x"}, "x"},
+		{"0*ln(x)+x", []string{"multiply by zero: (0 * ln(x)) -> 0", "add zero: (0 + x) -> x"}, "x"},
+		{"(2*3)/1", []string{"multiply constants: (2 * 3) -> 6", "divide by one: (6 / 1) -> 6"}, "6"},
+		{"-(-(x^1))", []string{"power of one: (x ^ 1) -> x", "double negation: --x -> x"}, "x"},
+		{"x+y", nil, "(x + y)"},
+	}
+	for _, test := range tests {
+		e, err := ParseExpression(test.q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rules []string
+		s := SimplifyWith(e, WithTracer(TracerFunc(func(rule string, before, after Expression) {
+			rules = append(rules, rule+": "+before.String()+" -> "+after.String())
+		})))
+		if s.String() != test.a || s.String() != e.Simplify().String() {
+			t.Errorf("%s simplified to %s with a tracer and %s without, wanted %s", test.q, s, e.Simplify(), test.a)
+		}
+		if strings.Join(rules, "\n") != strings.Join(test.rules, "\n") {
+			t.Errorf("%s traced\n%s\nwanted\n%s", test.q, strings.Join(rules, "\n"), strings.Join(test.rules, "\n"))
+		}
+	}
+
+	//Simplifying prints nothing
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	e, _ := ParseExpression("2*x*b*b*x/x+0*ln(x)")
+	e.Simplify()
+	SimplifyWith(e.Derive("x"))
+	os.Stdout = stdout
+	w.Close()
+	printed, _ := io.ReadAll(r)
+	if len(printed) > 0 {
+		t.Errorf("simplifying printed %q", printed)
+	}
+}
+
+func TestSimplifyWithSteps(t *testing.T) {
+	e, err := ParseExpression("(x*1)+(0*y)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, steps := SimplifyWithSteps(e)
+	if s.String() != "x" {
+		t.Errorf("(x*1)+(0*y) simplified to %s, wanted x", s)
+	}
+	want := []struct{ rule, str, latex string }{
+		{"multiply by one", "(x * 1) → x", `x \times 1 \rightarrow x`},
+		{"multiply by zero", "(0 * y) → 0", `0 \times y \rightarrow 0`},
+		{"add zero", "(x + 0) → x", `x + 0 \rightarrow x`},
+	}
+	if len(steps) != len(want) {
+		t.Fatalf("got steps %v, wanted %d", steps, len(want))
+	}
+	for i, step := range steps {
+		if step.Rule != want[i].rule || step.String() != want[i].str || step.Latex() != want[i].latex {
+			t.Errorf("step %d is %s: %s (%s), wanted %s: %s (%s)", i, step.Rule, step, step.Latex(), want[i].rule, want[i].str, want[i].latex)
+		}
+	}
+
+	//The last step ends at the result
+	e, err = ParseExpression("((2*3)*x)/1+0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, steps = SimplifyWithSteps(e)
+	if len(steps) != 3 || !Equal(steps[len(steps)-1].After, s) {
+		t.Errorf("%s simplified to %s but the steps were %v", e, s, steps)
+	}
+
+	//Derive simplifies its result so the steps are only seen from DeriveRaw
+	e, err = ParseExpression("x^2+3*x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := e.Derive("x")
+	if _, steps = SimplifyWithSteps(d); len(steps) != 0 {
+		t.Errorf("derivative %s was not simplified, steps %v", d, steps)
+	}
+	s, steps = SimplifyWithSteps(DeriveRaw(e, "x"))
+	if !Equal(s, d) {
+		t.Errorf("raw derivative of %s simplified to %s, wanted %s", e, s, d)
+	}
+	wantRules := []string{"multiply by one", "multiply by zero", "add zero", "combine like factors", "multiply by one", "multiply by zero", "add constants"}
+	rules := []string{}
+	for _, step := range steps {
+		rules = append(rules, step.Rule)
+	}
+	if strings.Join(rules, ", ") != strings.Join(wantRules, ", ") || !Equal(steps[len(steps)-1].After, Constant{3}) {
+		t.Errorf("raw derivative of %s simplified with steps %v", e, steps)
+	}
+}
+
 func TestCountMuls(t *testing.T) {
 	e, _ := ParseExpression("x+x/x")
 	fmt.Println("Expression,", e)
@@ -550,6 +2082,22 @@ func TestDerivativeN(t *testing.T) {
 			testNum: 9,
 			ans:     0,
 		},
+		//The product rule must use both factors, so these are 4x and not 3x or 6x
+		{
+			e:       "x*(2*x)",
+			testNum: 3,
+			ans:     12,
+		},
+		{
+			e:       "(2*x)*x",
+			testNum: 3,
+			ans:     12,
+		},
+		{
+			e:       "x*(x+1)",
+			testNum: 2,
+			ans:     5,
+		},
 	}
 	for i := range tests {
 		e, err := ParseExpression(tests[i].e)
@@ -563,6 +2111,18 @@ func TestDerivativeN(t *testing.T) {
 			t.Errorf("Expected (%g,%g). got (%g,%g). Derivative is %s", test.testNum, test.ans, test.testNum, res, d.String())
 		}
 	}
+
+	//The product rule is the same before it is simplified
+	for _, q := range []string{"x*(2*x)", "(2*x)*x"} {
+		e, err := ParseExpression(q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw := DeriveRaw(e, "x")
+		if res := raw.Evaluate(map[string]float64{"x": 3}); res != 12 {
+			t.Errorf("Raw derivative of %s at 3 should be 12 but was %g. Raw derivative is %s", q, res, raw.String())
+		}
+	}
 }
 
 // ================ Benchmarks ================
@@ -594,6 +2154,56 @@ func BenchmarkExpressionCompiled(b *testing.B) {
 	}
 	result = r
 }
+func BenchmarkExpressionCompiledVars(b *testing.B) {
+	e, _ := ParseExpression("2*x+y*5-x*y+3*z")
+	f := CompileExpression(e)
+	vars := map[string]float64{"x": 1, "y": 2, "z": 3}
+	var r float64
+	for n := 0; n < b.N; n++ {
+		r = f(vars)
+	}
+	result = r
+}
+func BenchmarkExpressionCompiledArgs(b *testing.B) {
+	e, _ := ParseExpression("2*x+y*5-x*y+3*z")
+	_, f := CompileExpressionArgs(e)
+	args := []float64{1, 2, 3}
+	var r float64
+	for n := 0; n < b.N; n++ {
+		r = f(args)
+	}
+	result = r
+}
+func batchBenchmarkColumns() (map[string][]float64, []float64) {
+	rows := 10000
+	columns := map[string][]float64{"x": make([]float64, rows), "y": make([]float64, rows)}
+	for i := 0; i < rows; i++ {
+		columns["x"][i] = float64(i)
+		columns["y"][i] = float64(rows - i)
+	}
+	return columns, make([]float64, rows)
+}
+func BenchmarkEvalRows(b *testing.B) {
+	e, _ := ParseExpression("2*x+y*5-x*y/3+x^2")
+	f := CompileExpression(e)
+	columns, out := batchBenchmarkColumns()
+	vars := map[string]float64{}
+	for n := 0; n < b.N; n++ {
+		for i := range out {
+			vars["x"] = columns["x"][i]
+			vars["y"] = columns["y"][i]
+			out[i] = f(vars)
+		}
+	}
+}
+func BenchmarkEvalBatch(b *testing.B) {
+	e, _ := ParseExpression("2*x+y*5-x*y/3+x^2")
+	p, _ := CompileProgram(e)
+	columns, out := batchBenchmarkColumns()
+	for n := 0; n < b.N; n++ {
+		p.EvalBatch(columns, out)
+	}
+}
 func BenchmarkExpressionNative(b *testing.B) {
 	//Parse the expression
 	//expr := "2+2*5+3*5+2*5+3*72"
@@ -607,53 +2217,125 @@ func BenchmarkExpressionNative(b *testing.B) {
 }
 
 func BenchmarkExpressionJIT(b *testing.B) {
-	////Parse the expression
-	//expr := "2+2+3+4"
-	//e, _ := ParseExpression(expr)
-	//f := JitCompileExpression(e)
-	//var r float64
-	//// evaluate the expression N times
-	//for n := 0; n < b.N; n++ {
-	//	r = f(map[string]float64{})
-	//}
-	//result = r
-}
-
-//Segmentation and or nil pointer dereference
-//something to do with way executablePrintFunc is made?
-//Maybe with way it is copied over
-//Maybe the way it is compiled but i doubt that a bit
+	//The same expression as BenchmarkExpressionCompiledArgs
+	e, _ := ParseExpression("2*x+y*5-x*y+3*z")
+	_, f := JitCompileExpressionArgs(e)
+	args := []float64{1, 2, 3}
+	var r float64
+	for n := 0; n < b.N; n++ {
+		r = f(args)
+	}
+	result = r
+}
+func BenchmarkExpressionCompiledCalls(b *testing.B) {
+	e, _ := ParseExpression("sin(x)*y+cos(z)^2-x*y*z+sqrt(x+y)")
+	_, f := CompileExpressionArgs(e)
+	args := []float64{1, 2, 3}
+	var r float64
+	for n := 0; n < b.N; n++ {
+		r = f(args)
+	}
+	result = r
+}
+func BenchmarkExpressionJITCalls(b *testing.B) {
+	e, _ := ParseExpression("sin(x)*y+cos(z)^2-x*y*z+sqrt(x+y)")
+	_, f := JitCompileExpressionArgs(e)
+	args := []float64{1, 2, 3}
+	var r float64
+	for n := 0; n < b.N; n++ {
+		r = f(args)
+	}
+	result = r
+}
 
 func TestExpressionJIT(t *testing.T) {
-	mathFunction2 := []uint8{
-		//Setup stuff
-		0x48, 0x83, 0xec, 0x18, 0x48, 0x89, 0x6c, 0x24, 0x10, 0x48, 0x8d, 0x6c, 0x24, 0x10, 0x48, 0x89,
-		0x44, 0x24, 0x20, 0x48, 0x85, 0xdb, 0x76, 0x43,
+	if runtime.GOARCH != "amd64" {
+		t.Skip("machine code is amd64")
+	}
+	//movsd xmm0, [rax+8]; addsd xmm0, [rax+16]; ret
+	mathFunction := []uint8{
+		0xf2, 0x0f, 0x10, 0x40, 0x08,
+		0xf2, 0x0f, 0x58, 0x40, 0x10,
+		0xc3,
+	}
+	data := []float64{1, 2, 3}
 
-		//data[3]+data[0]+data[1]
-		0xf2, 0x0f, 0x10, 0x00, //MOVESD
+	f := MakeMathFunc(mathFunction)
 
-		//Returning stuff
-		0x48, 0x8b, 0x6c, 0x24, 0x10,
-		0x48, 0x83, 0xc4, 0x18, 0x90, 0xc3, 0xb8, 0x02, 0x00, 0x00, 0x00, 0x48, 0x89, 0xd9, 0xe8,
+	if res := f(data); res != 5 {
+		t.Errorf("Machine code should return data[1]+data[2] = 5 but returned %g", res)
+	}
+	//Parse the expression
+	expr := "2+2+3+4"
+	e, err := ParseExpression(expr)
+	if err != nil {
+		t.Errorf(err.Error())
+		return
+	}
+	jf := JitCompileExpression(e)
+	var r = jf(map[string]float64{"a": 1})
+	if r != float64(2+2+3+4) {
+		t.Errorf("Had 2+2+3+4. Wanted %g, got %g", float64(2+2+3+4), r)
 	}
-	data := []float64{1, 2, 3, -8008, -8008}
+}
 
-	f := MakeMathFunc(mathFunction2)
+func TestJitFunctionClose(t *testing.T) {
+	e, err := ParseExpression("sin(x)*2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := NewJitFunction(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := f.Evaluate(map[string]float64{"x": 1}); res != math.Sin(1)*2 {
+		t.Errorf("sin(x)*2 should = %g but evaluated to %g", math.Sin(1)*2, res)
+	}
+	f.Close()
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Evaluate after Close should panic")
+		}
+	}()
+	f.Evaluate(map[string]float64{"x": 1})
+}
 
-	res := f(data)
-	fmt.Println(res)
-	//Parse the expression
-	//expr := "2+2+3+4"
-	//e, err := ParseExpression(expr)
-	//fmt.Println(e.String())
-	//if err != nil {
-	//	t.Errorf(err.Error())
-	//	return
-	//}
-	//f := JitCompileExpression(e)
-	//var r = f(map[string]float64{"a": 1})
-	//if r != float64(2+2+3+4) {
-	//	t.Errorf("Had 2+2+3+4. Wanted %g, got %g", float64(2+2+3+4), r)
-	//}
+func TestJitN(t *testing.T) {
+	vars := map[string]float64{"x": 0.3, "y": -2}
+	tests := []string{
+		"x",
+		"5",
+		"x+y",
+		"x-y*3/x",
+		"-x",
+		"-(x-y)",
+		"abs(y)",
+		"abs(-0.5)",
+		"sqrt(x+1)",
+		"x^y",
+		"sin(x)+cos(y)*ln(x)",
+		"tan(x)+exp(x)-log10(x)+log2(x)",
+		"asin(x)+acos(x)*atan(y)+sinh(x)-cosh(y)/tanh(x)",
+		"max(x, y, 1)+min(x, y)*atan2(y, x)",
+		"-sin(-x)*2+sqrt(abs(y))",
+		"1/(x-0.3)",
+	}
+	for _, q := range tests {
+		e, err := ParseExpression(q)
+		if err != nil {
+			t.Errorf("%s failed to parse: %v", q, err)
+			continue
+		}
+		f := JitCompileExpression(e)
+		want := e.Evaluate(vars)
+		//Run twice to check the code does not ruin its own memory
+		for run := 0; run < 2; run++ {
+			if res := f(vars); res != want {
+				t.Errorf("%s should = %g but jit compiled version evaluated to %g", q, want, res)
+			}
+		}
+		if res := f(map[string]float64{"x": 0.5, "y": 1}); res != e.Evaluate(map[string]float64{"x": 0.5, "y": 1}) {
+			t.Errorf("%s gave %g with new variables, wanted %g", q, res, e.Evaluate(map[string]float64{"x": 0.5, "y": 1}))
+		}
+	}
 }
@@ -0,0 +1,219 @@
+package parser
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Program is an expression compiled to instructions on registers
+// The registers hold the constants, the variables and the results of instructions
+// A program does not change once it is made so it can be run by several goroutines at once
+type Program struct {
+	code []Instruction
+	//memory is the starting value of every register
+	memory       []float64
+	varLocations map[string]int
+	calls        []call
+	//result is the register holding the value of the expression once the code has run
+	result int
+
+	//varNames and varRegisters are the variables and their registers in the order they are set
+	varNames     []string
+	varRegisters []int
+	//scratch holds *[]float64 that each run of the program has to itself
+	scratch sync.Pool
+	//batchScratch holds *batchMemory for EvalBatch
+	batchScratch sync.Pool
+}
+
+// CompileProgram compiles e to a program
+func CompileProgram(e Expression, opts ...CompileOption) (*Program, error) {
+	mm := NewMemoryManager()
+	result := e.Compile(&mm)
+	return NewProgram(&mm, result, opts...)
+}
+
+// NewProgram makes a program of the code in mm whose result is in register result
+// It returns an error if the code is malformed
+func NewProgram(mm *MemoryManager, result int, opts ...CompileOption) (*Program, error) {
+	options := makeCompileOptions(opts)
+	p := &Program{
+		code:         append([]Instruction(nil), mm.code...),
+		memory:       append([]float64(nil), mm.constants...),
+		varLocations: map[string]int{},
+		calls:        append([]call(nil), mm.calls...),
+		result:       result,
+	}
+	for k, v := range mm.varLocations {
+		p.varLocations[k] = v
+		p.varNames = append(p.varNames, k)
+	}
+	sort.Strings(p.varNames)
+	for _, k := range p.varNames {
+		p.varRegisters = append(p.varRegisters, p.varLocations[k])
+	}
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	if options.optimize {
+		p.optimize()
+		p.allocateRegisters()
+	}
+	return p, nil
+}
+
+// getScratch returns memory to run the program in, which must be given back to p.scratch afterwards
+// It holds the registers followed by room for the arguments of the biggest function call
+// The constants in it are never written and every other register is written before it is read so it
+// does not have to be reset between runs
+func (p *Program) getScratch() *[]float64 {
+	if mem, ok := p.scratch.Get().(*[]float64); ok {
+		return mem
+	}
+	mem := make([]float64, len(p.memory)+p.maxCallArgs())
+	copy(mem, p.memory)
+	return &mem
+}
+
+// Instructions returns a copy of the code of the program
+func (p *Program) Instructions() []Instruction {
+	return append([]Instruction(nil), p.code...)
+}
+
+// validate checks that every instruction is known and only reads registers that exist and hold a value
+func (p *Program) validate() error {
+	inRange := func(r int) bool { return r >= 0 && r < len(p.memory) }
+	variables := map[int]bool{}
+	for name, r := range p.varLocations {
+		if !inRange(r) {
+			return fmt.Errorf("variable %s is in register %d of %d", name, r, len(p.memory))
+		}
+		variables[r] = true
+	}
+	if !inRange(p.result) {
+		return fmt.Errorf("result is in register %d of %d", p.result, len(p.memory))
+	}
+	//Registers that are written by the code must be written before they are read
+	willWrite := map[int]bool{}
+	for _, ins := range p.code {
+		willWrite[ins.Dst] = true
+	}
+	written := map[int]bool{}
+	canRead := func(r int) bool { return inRange(r) && (!willWrite[r] || written[r]) }
+
+	for i, ins := range p.code {
+		if !ins.Op.valid() {
+			return fmt.Errorf("instruction %d: unknown operation %d", i, int(ins.Op))
+		}
+		if !inRange(ins.Dst) {
+			return fmt.Errorf("instruction %d (%s): result register %d of %d", i, ins.Op, ins.Dst, len(p.memory))
+		}
+		if variables[ins.Dst] {
+			return fmt.Errorf("instruction %d (%s): writes to variable register %d", i, ins.Op, ins.Dst)
+		}
+		var reads []int
+		switch ins.Op.operands() {
+		case 0:
+			if ins.A < 0 || ins.A >= len(p.calls) {
+				return fmt.Errorf("instruction %d (%s): call %d of %d", i, ins.Op, ins.A, len(p.calls))
+			}
+			if ins.B != 0 {
+				return fmt.Errorf("instruction %d (%s): unused operand B is %d", i, ins.Op, ins.B)
+			}
+			c := p.calls[ins.A]
+			if c.fn == nil || !c.fn.acceptsArgs(len(c.args)) {
+				return fmt.Errorf("instruction %d (%s): wrong number of arguments %d", i, ins.Op, len(c.args))
+			}
+			reads = c.args
+		case 1:
+			if ins.B != 0 {
+				return fmt.Errorf("instruction %d (%s): unused operand B is %d", i, ins.Op, ins.B)
+			}
+			reads = []int{ins.A}
+		case 2:
+			reads = []int{ins.A, ins.B}
+		}
+		for _, r := range reads {
+			if !canRead(r) {
+				return fmt.Errorf("instruction %d (%s): reads register %d which does not hold a value", i, ins.Op, r)
+			}
+		}
+		written[ins.Dst] = true
+	}
+	if !canRead(p.result) {
+		return fmt.Errorf("result register %d does not hold a value", p.result)
+	}
+	return nil
+}
+
+// maxCallArgs finds the most arguments passed to any function call in the code
+func (p *Program) maxCallArgs() int {
+	most := 0
+	for _, c := range p.calls {
+		if len(c.args) > most {
+			most = len(c.args)
+		}
+	}
+	return most
+}
+
+// Disassemble returns the program as text, one register or instruction per line
+// The constants and variables come first, then the instructions in the order they run, then the register of the result
+func (p *Program) Disassemble() string {
+	var sb strings.Builder
+	written := map[int]bool{}
+	read := map[int]bool{p.result: true}
+	for _, ins := range p.code {
+		written[ins.Dst] = true
+		switch ins.Op.operands() {
+		case 0:
+			for _, r := range p.calls[ins.A].args {
+				read[r] = true
+			}
+		case 1:
+			read[ins.A] = true
+		case 2:
+			read[ins.A] = true
+			read[ins.B] = true
+		}
+	}
+	names := map[int]string{}
+	for name, r := range p.varLocations {
+		names[r] = name
+		read[r] = true
+	}
+	registers := make([]int, 0, len(p.memory))
+	for r := range p.memory {
+		if read[r] && !written[r] {
+			registers = append(registers, r)
+		}
+	}
+	sort.Ints(registers)
+	for _, r := range registers {
+		if name, ok := names[r]; ok {
+			fmt.Fprintf(&sb, "r%d = %s\n", r, name)
+		} else {
+			fmt.Fprintf(&sb, "r%d = %s\n", r, strconv.FormatFloat(p.memory[r], 'g', -1, 64))
+		}
+	}
+	for _, ins := range p.code {
+		switch ins.Op.operands() {
+		case 0:
+			c := p.calls[ins.A]
+			args := make([]string, len(c.args))
+			for i, r := range c.args {
+				args[i] = "r" + strconv.Itoa(r)
+			}
+			fmt.Fprintf(&sb, "r%d = %s %s(%s)\n", ins.Dst, ins.Op, c.fn.Name, strings.Join(args, ", "))
+		case 1:
+			fmt.Fprintf(&sb, "r%d = %s r%d\n", ins.Dst, ins.Op, ins.A)
+		case 2:
+			fmt.Fprintf(&sb, "r%d = %s r%d, r%d\n", ins.Dst, ins.Op, ins.A, ins.B)
+		}
+	}
+	fmt.Fprintf(&sb, "return r%d\n", p.result)
+	return sb.String()
+}
@@ -0,0 +1,121 @@
+package parser
+
+// RegisterCounts returns how many registers of the program hold constants, variables and the results of instructions
+// The last is the most scratch registers the program needs at once when it has been optimized
+func (p *Program) RegisterCounts() (constants, variables, scratch int) {
+	written := make([]bool, len(p.memory))
+	for _, ins := range p.code {
+		written[ins.Dst] = true
+	}
+	for r := range p.memory {
+		if written[r] {
+			scratch++
+		}
+	}
+	variables = len(p.varLocations)
+	constants = len(p.memory) - variables - scratch
+	return constants, variables, scratch
+}
+
+// allocateRegisters renumbers the registers so the constants come first, then the variables, then the scratch
+// registers holding the results of instructions. A scratch register is reused once the value in it is not needed
+// Constants that are never read are dropped. Programs that write a register more than once are left alone
+func (p *Program) allocateRegisters() {
+	written := make([]bool, len(p.memory))
+	//lastRead[r] is the index of the last instruction reading r, or len(p.code) for the result
+	lastRead := make([]int, len(p.memory))
+	for r := range lastRead {
+		lastRead[r] = -1
+	}
+	for i, ins := range p.code {
+		if written[ins.Dst] {
+			return
+		}
+		written[ins.Dst] = true
+		for _, r := range p.reads(ins) {
+			lastRead[r] = i
+		}
+	}
+	lastRead[p.result] = len(p.code)
+
+	newReg := make([]int, len(p.memory))
+	memory := make([]float64, 0, len(p.memory))
+	isVariable := make([]bool, len(p.memory))
+	for _, r := range p.varRegisters {
+		isVariable[r] = true
+	}
+	for r := range p.memory {
+		if !written[r] && !isVariable[r] && lastRead[r] >= 0 {
+			newReg[r] = len(memory)
+			memory = append(memory, p.memory[r])
+		}
+	}
+	for i, name := range p.varNames {
+		r := p.varRegisters[i]
+		newReg[r] = len(memory)
+		memory = append(memory, 0)
+		p.varRegisters[i] = newReg[r]
+		p.varLocations[name] = newReg[r]
+	}
+
+	free := []int{}
+	code := make([]Instruction, len(p.code))
+	calls := make([]call, len(p.calls))
+	for i, ins := range p.code {
+		reads := p.reads(ins)
+		switch ins.Op.operands() {
+		case 0:
+			c := call{fn: p.calls[ins.A].fn, args: make([]int, len(reads))}
+			for j, r := range reads {
+				c.args[j] = newReg[r]
+			}
+			calls[ins.A] = c
+		case 1:
+			ins.A = newReg[ins.A]
+		case 2:
+			ins.A, ins.B = newReg[ins.A], newReg[ins.B]
+		}
+		//Registers read for the last time can hold the result as every operand is read before it is written
+		for j, r := range reads {
+			if written[r] && lastRead[r] == i && !contains(reads[:j], r) {
+				free = append(free, newReg[r])
+			}
+		}
+		if n := len(free); n > 0 {
+			ins.Dst, free = free[n-1], free[:n-1]
+		} else {
+			ins.Dst = len(memory)
+			memory = append(memory, 0)
+		}
+		newReg[p.code[i].Dst] = ins.Dst
+		if lastRead[p.code[i].Dst] < i {
+			//Never read
+			free = append(free, ins.Dst)
+		}
+		code[i] = ins
+	}
+	p.result = newReg[p.result]
+	p.memory = memory
+	p.code = code
+	p.calls = calls
+}
+
+// reads returns the registers an instruction reads
+func (p *Program) reads(ins Instruction) []int {
+	switch ins.Op.operands() {
+	case 0:
+		return p.calls[ins.A].args
+	case 1:
+		return []int{ins.A}
+	}
+	return []int{ins.A, ins.B}
+}
+
+func contains(rs []int, r int) bool {
+	for _, v := range rs {
+		if v == r {
+			return true
+		}
+	}
+	return false
+}
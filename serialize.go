@@ -0,0 +1,214 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+)
+
+// programMagic starts every serialized program
+const programMagic = "EXPR"
+
+// programVersion is the version of the serialized format. Programs of other versions are not loaded
+const programVersion = 1
+
+// MarshalBinary serializes the program: its instructions, the starting value of every register, the registers of the variables,
+// the functions it calls by name and the register of its result
+func (p *Program) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(programMagic)
+	writeUvarint(&buf, programVersion)
+
+	writeUvarint(&buf, uint64(len(p.memory)))
+	for _, v := range p.memory {
+		var b [8]byte
+		binary.LittleEndian.PutUint64(b[:], math.Float64bits(v))
+		buf.Write(b[:])
+	}
+	writeUvarint(&buf, uint64(len(p.varNames)))
+	for i, name := range p.varNames {
+		writeString(&buf, name)
+		writeUvarint(&buf, uint64(p.varRegisters[i]))
+	}
+	writeUvarint(&buf, uint64(len(p.calls)))
+	for _, c := range p.calls {
+		writeString(&buf, c.fn.Name)
+		writeUvarint(&buf, uint64(len(c.args)))
+		for _, r := range c.args {
+			writeUvarint(&buf, uint64(r))
+		}
+	}
+	writeUvarint(&buf, uint64(len(p.code)))
+	for _, ins := range p.code {
+		writeUvarint(&buf, uint64(ins.Op))
+		writeUvarint(&buf, uint64(ins.A))
+		writeUvarint(&buf, uint64(ins.B))
+		writeUvarint(&buf, uint64(ins.Dst))
+	}
+	writeUvarint(&buf, uint64(p.result))
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary loads a program serialized by MarshalBinary, finding the functions it calls among the built in functions
+// It returns an error if the data is not a valid program
+func (p *Program) UnmarshalBinary(data []byte) error {
+	return p.unmarshal(data, defaultFunctions)
+}
+
+// LoadProgram loads a program serialized by MarshalBinary, finding the functions it calls in r
+// If r is nil the built in functions are used
+func LoadProgram(data []byte, r *FunctionRegistry) (*Program, error) {
+	if r == nil {
+		r = defaultFunctions
+	}
+	p := &Program{}
+	if err := p.unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Program) unmarshal(data []byte, functions *FunctionRegistry) error {
+	if !bytes.HasPrefix(data, []byte(programMagic)) {
+		return errors.New("not a serialized program")
+	}
+	rd := &programReader{data: data[len(programMagic):]}
+	if version := rd.uvarint(); rd.err == nil && version != programVersion {
+		return fmt.Errorf("serialized program is version %d, only version %d can be loaded", version, programVersion)
+	}
+
+	memory := make([]float64, rd.count(8))
+	for i := range memory {
+		memory[i] = rd.float()
+	}
+	varLocations := map[string]int{}
+	for n := rd.count(2); n > 0 && rd.err == nil; n-- {
+		name := rd.string()
+		if _, ok := varLocations[name]; ok {
+			rd.fail(fmt.Errorf("variable %s is in two registers", name))
+		}
+		varLocations[name] = rd.int()
+	}
+	calls := make([]call, rd.count(2))
+	for i := range calls {
+		name := rd.string()
+		f, ok := functions.Lookup(name)
+		if !ok && rd.err == nil {
+			rd.fail(fmt.Errorf("unknown function %s", name))
+		}
+		args := make([]int, rd.count(1))
+		for j := range args {
+			args[j] = rd.int()
+		}
+		calls[i] = call{fn: f, args: args}
+	}
+	code := make([]Instruction, rd.count(4))
+	for i := range code {
+		code[i] = Instruction{Op: Bytecode(rd.int()), A: rd.int(), B: rd.int(), Dst: rd.int()}
+	}
+	result := rd.int()
+	if rd.err == nil && len(rd.data) > 0 {
+		rd.fail(fmt.Errorf("%d bytes after the end of the program", len(rd.data)))
+	}
+	if rd.err != nil {
+		return fmt.Errorf("serialized program: %w", rd.err)
+	}
+
+	loaded, err := NewProgram(&MemoryManager{code: code, constants: memory, varLocations: varLocations, calls: calls}, result, WithOptimization(false))
+	if err != nil {
+		return fmt.Errorf("serialized program: %w", err)
+	}
+	p.code = loaded.code
+	p.memory = loaded.memory
+	p.varLocations = loaded.varLocations
+	p.calls = loaded.calls
+	p.result = loaded.result
+	p.varNames = loaded.varNames
+	p.varRegisters = loaded.varRegisters
+	p.scratch = sync.Pool{}
+	p.batchScratch = sync.Pool{}
+	return nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	buf.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+// programReader reads a serialized program, remembering the first error so it can be checked once at the end
+type programReader struct {
+	data []byte
+	err  error
+}
+
+func (rd *programReader) fail(err error) {
+	if rd.err == nil {
+		rd.err = err
+	}
+	rd.data = nil
+}
+
+func (rd *programReader) uvarint() uint64 {
+	if rd.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(rd.data)
+	if n <= 0 {
+		rd.fail(errors.New("truncated or invalid number"))
+		return 0
+	}
+	rd.data = rd.data[n:]
+	return v
+}
+
+// int reads a number that has to fit in an int
+func (rd *programReader) int() int {
+	v := rd.uvarint()
+	if v > math.MaxInt32 {
+		rd.fail(fmt.Errorf("number %d too big", v))
+		return 0
+	}
+	return int(v)
+}
+
+// count reads the length of a list whose items each take at least size bytes
+// so a corrupt length can not make a huge allocation
+func (rd *programReader) count(size int) int {
+	n := rd.int()
+	if n > len(rd.data)/size {
+		rd.fail(fmt.Errorf("list of %d items is longer than the data", n))
+		return 0
+	}
+	return n
+}
+
+func (rd *programReader) float() float64 {
+	if rd.err != nil {
+		return 0
+	}
+	if len(rd.data) < 8 {
+		rd.fail(errors.New("truncated number"))
+		return 0
+	}
+	v := math.Float64frombits(binary.LittleEndian.Uint64(rd.data))
+	rd.data = rd.data[8:]
+	return v
+}
+
+func (rd *programReader) string() string {
+	n := rd.count(1)
+	if rd.err != nil {
+		return ""
+	}
+	s := string(rd.data[:n])
+	rd.data = rd.data[n:]
+	return s
+}
@@ -0,0 +1,419 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+)
+
+// defaultMaxIterations is the most passes a Simplifier makes over an expression unless WithMaxIterations is given
+const defaultMaxIterations = 32
+
+// maxExpandedTerms is the most terms the expand rules will multiply a whole power of a sum out to
+const maxExpandedTerms = 1024
+
+// Rule rewrites an expression. It is given each subexpression after the children of that subexpression have been simplified
+type Rule struct {
+	// Name says what the rule does, such as "distribute". It is the rule a Tracer is told was applied
+	Name string
+	// Rewrite returns what e becomes and true, or false if the rule does not apply to e. It must not be nil
+	Rewrite func(e Expression) (Expression, bool)
+
+	// local is used in place of Rewrite by rules that report their own rewrites
+	local func(rw *rewriter, e Expression) Expression
+}
+
+// check panics if the rule has nothing to apply, so a bad rule is found when it is added rather than while simplifying
+func (r Rule) check() {
+	if r.Rewrite == nil && r.local == nil {
+		panic(fmt.Sprintf("rule %q has no Rewrite function", r.Name))
+	}
+}
+
+// apply applies the rule to e, reporting the rewrite to rw
+func (r Rule) apply(rw *rewriter, e Expression) Expression {
+	if r.local != nil {
+		return r.local(rw, e)
+	}
+	after, ok := r.Rewrite(e)
+	if !ok {
+		return e
+	}
+	return rw.rewrite(r.Name, e, after)
+}
+
+// RuleSet is a named list of rules that are tried in order
+type RuleSet struct {
+	Name  string
+	Rules []Rule
+}
+
+// BasicRules are the rewrites made by Simplify: folding numbers, identities like x*1 and x+0 and combining like terms and factors
+var BasicRules = RuleSet{
+	Name: "basic identities",
+	Rules: []Rule{{
+		Name: "basic identities",
+		Rewrite: func(e Expression) (Expression, bool) {
+			s := simplifyLocal(&rewriter{local: true}, e)
+			return s, !Equal(s, e)
+		},
+		local: simplifyLocal,
+	}},
+}
+
+// ExpandRules multiply out products, quotients and whole powers of sums, so x*(x+1) becomes x*x+x*1
+// They undo FactorRules so the two should not be used together
+var ExpandRules = RuleSet{
+	Name: "expand",
+	Rules: []Rule{
+		{Name: "distribute", Rewrite: distribute},
+		{Name: "expand power", Rewrite: expandPower},
+	},
+}
+
+// FactorRules take the factors every term of a sum has in common out of it, so x^2+2*x becomes x*(x+2)
+var FactorRules = RuleSet{
+	Name: "factor",
+	Rules: []Rule{
+		{Name: "factor out common factors", Rewrite: factorSum},
+	},
+}
+
+// TrigRules apply trigonometric identities: sin(u)^2+cos(u)^2 = 1, sin(-u) = -sin(u), cos(-u) = cos(u),
+// tan(-u) = -tan(u) and sin(u)/cos(u) = tan(u)
+var TrigRules = RuleSet{
+	Name: "trig",
+	Rules: []Rule{
+		{Name: "pythagorean identity", Rewrite: pythagorean},
+		{Name: "negative angle", Rewrite: negativeAngle},
+		{Name: "tangent", Rewrite: tangent},
+	},
+}
+
+// Simplifier simplifies expressions by applying its rules to every subexpression from the bottom up, over and over,
+// until the expression stops changing or it has made as many passes as it is allowed
+// It is safe to use from several goroutines at once as long as AddRule is not called at the same time
+type Simplifier struct {
+	sets          []RuleSet
+	extra         []Rule
+	rules         []Rule
+	maxIterations int
+	tracer        Tracer
+}
+
+// SimplifyOption changes how a Simplifier simplifies
+type SimplifyOption func(*Simplifier)
+
+// WithRuleSets has the Simplifier use the rules of sets, in order, in place of BasicRules
+func WithRuleSets(sets ...RuleSet) SimplifyOption {
+	return func(s *Simplifier) {
+		s.sets = sets
+	}
+}
+
+// WithRules has the Simplifier also use rules, after those of its rule sets
+func WithRules(rules ...Rule) SimplifyOption {
+	return func(s *Simplifier) {
+		s.extra = append(s.extra, rules...)
+	}
+}
+
+// WithMaxIterations sets the most passes the Simplifier makes over an expression. Rules that undo each other
+// would otherwise never stop. It is 32 by default
+func WithMaxIterations(n int) SimplifyOption {
+	return func(s *Simplifier) {
+		s.maxIterations = n
+	}
+}
+
+// NewSimplifier returns a Simplifier using BasicRules unless other rule sets are given with WithRuleSets
+// It panics if a rule has no Rewrite function
+func NewSimplifier(opts ...SimplifyOption) *Simplifier {
+	s := &Simplifier{
+		sets:          []RuleSet{BasicRules},
+		maxIterations: defaultMaxIterations,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	for _, set := range s.sets {
+		s.rules = append(s.rules, set.Rules...)
+	}
+	s.rules = append(s.rules, s.extra...)
+	for _, r := range s.rules {
+		r.check()
+	}
+	return s
+}
+
+// AddRule adds a rule to be tried after all the others. It panics if the rule has no Rewrite function
+func (s *Simplifier) AddRule(r Rule) {
+	r.check()
+	s.rules = append(s.rules, r)
+}
+
+// Simplify simplifies e until it stops changing
+func (s *Simplifier) Simplify(e Expression) Expression {
+	rw := &rewriter{tracer: s.tracer, local: true}
+	applyRules := func(e Expression) Expression {
+		for _, r := range s.rules {
+			e = r.apply(rw, e)
+		}
+		return e
+	}
+	for i := 0; i < s.maxIterations; i++ {
+		next := Rewrite(e, applyRules)
+		if Equal(next, e) {
+			return next
+		}
+		e = next
+	}
+	return e
+}
+
+// simplifyLocal simplifies e, whose children are already simplified if rw is local
+func simplifyLocal(rw *rewriter, e Expression) Expression {
+	if r, ok := e.(rewritable); ok {
+		return r.simplify(rw)
+	}
+	return e.Simplify()
+}
+
+// isSum reports whether e is an addition or subtraction
+func isSum(e Expression) bool {
+	switch e.(type) {
+	case Adder, Subtractor:
+		return true
+	}
+	return false
+}
+
+// multiplyTerms multiplies every term of a by every term of b
+func multiplyTerms(a, b []term) []term {
+	product := make([]term, 0, len(a)*len(b))
+	for _, ta := range a {
+		for _, tb := range b {
+			t := term{coefficient: ta.coefficient * tb.coefficient}
+			switch {
+			case ta.base == nil:
+				t.base = tb.base
+			case tb.base == nil:
+				t.base = ta.base
+			default:
+				t.base = Multiplier{A: ta.base, B: tb.base}
+			}
+			product = append(product, t)
+		}
+	}
+	return product
+}
+
+// distribute multiplies a product of sums out, so (a+b)*(c-d) is a*c-a*d+b*c-b*d, and divides each term of a sum over something
+func distribute(e Expression) (Expression, bool) {
+	switch v := e.(type) {
+	case Multiplier:
+		if !isSum(v.A) && !isSum(v.B) {
+			return e, false
+		}
+		return sumOf(multiplyTerms(collectTerms(v.A, 1, nil), collectTerms(v.B, 1, nil))), true
+	case Divider:
+		if !isSum(v.A) {
+			return e, false
+		}
+		terms := collectTerms(v.A, 1, nil)
+		for i, t := range terms {
+			if t.base == nil {
+				terms[i] = term{coefficient: 1, base: Divider{A: Constant{t.coefficient}, B: v.B}}
+			} else {
+				terms[i].base = Divider{A: t.base, B: v.B}
+			}
+		}
+		return sumOf(terms), true
+	}
+	return e, false
+}
+
+// expandPower multiplies out a sum raised to a whole number, so (a+b)^2 is a*a+a*b+b*a+b*b
+func expandPower(e Expression) (Expression, bool) {
+	p, ok := e.(Powerer)
+	if !ok || !isSum(p.Base) {
+		return e, false
+	}
+	n, ok := p.Exponent.(Constant)
+	if !ok || n.Value < 2 || n.Value != math.Trunc(n.Value) {
+		return e, false
+	}
+	terms := collectTerms(p.Base, 1, nil)
+	if math.Pow(float64(len(terms)), n.Value) > maxExpandedTerms {
+		return e, false
+	}
+	product := terms
+	for i := 1; i < int(n.Value); i++ {
+		product = multiplyTerms(product, terms)
+	}
+	return sumOf(product), true
+}
+
+// factorSum takes the factors every term of a sum has in common out of it, along with the greatest common divisor of their
+// coefficients if they are all whole numbers, so 2*x^2+4*x is 2*x*(x+2)
+func factorSum(e Expression) (Expression, bool) {
+	if !isSum(e) {
+		return e, false
+	}
+	terms := collectTerms(e, 1, nil)
+
+	//The power each factor is raised to in every term so far
+	bases := newCommutativeExpressionIndex()
+	var common []float64
+	divisor := 0.0
+	for i, t := range terms {
+		if divisor >= 0 && t.coefficient == math.Trunc(t.coefficient) && !math.IsInf(t.coefficient, 0) {
+			divisor = gcd(divisor, math.Abs(t.coefficient))
+		} else {
+			divisor = -1
+		}
+		powers := make([]float64, len(common))
+		if t.base != nil {
+			num, _ := ListMuls(t.base, true)
+			for _, f := range num {
+				base, power := f, 1.0
+				if p, ok := f.(Powerer); ok {
+					if c, ok := p.Exponent.(Constant); ok && c.Value > 0 {
+						base, power = p.Base, c.Value
+					}
+				}
+				if _, ok := base.(Constant); ok {
+					continue
+				}
+				j, found := bases.Find(base)
+				if i == 0 && !found {
+					j = bases.Add(base)
+					common = append(common, 0)
+					powers = append(powers, 0)
+				}
+				if i == 0 || found {
+					powers[j] += power
+				}
+			}
+		}
+		for j := range common {
+			if i == 0 || powers[j] < common[j] {
+				common[j] = powers[j]
+			}
+		}
+	}
+
+	commonParts := []Expression{}
+	for j, power := range common {
+		if power == 1 {
+			commonParts = append(commonParts, bases.At(j))
+		} else if power > 0 {
+			commonParts = append(commonParts, Powerer{Base: bases.At(j), Exponent: Constant{power}})
+		}
+	}
+	if divisor <= 1 {
+		divisor = 1
+	}
+	if len(commonParts) == 0 && divisor == 1 {
+		return e, false
+	}
+
+	for i, t := range terms {
+		t.coefficient /= divisor
+		if t.base != nil {
+			num, denom := ListMuls(t.base, true)
+			coefficient, base := splitCoefficient(SimplifyFraction(num, append(denom, commonParts...)))
+			t = term{coefficient: t.coefficient * coefficient, base: base}
+		}
+		terms[i] = t
+	}
+	return Multiplier{
+		A: multiplyParts(divisor, commonParts),
+		B: sumOf(terms),
+	}, true
+}
+
+// gcd returns the greatest common divisor of two whole numbers
+func gcd(a, b float64) float64 {
+	for b != 0 {
+		a, b = b, math.Mod(a, b)
+	}
+	return a
+}
+
+// trigSquare returns u if e is sin(u)^2 or cos(u)^2, and whether it is the sine
+func trigSquare(e Expression) (u Expression, sine bool, ok bool) {
+	p, ok := e.(Powerer)
+	if !ok || !Equal(p.Exponent, Constant{2}) {
+		return nil, false, false
+	}
+	switch v := p.Base.(type) {
+	case Siner:
+		return v.A, true, true
+	case Coser:
+		return v.A, false, true
+	}
+	return nil, false, false
+}
+
+// pythagorean replaces a*sin(u)^2+a*cos(u)^2 in a sum with a
+func pythagorean(e Expression) (Expression, bool) {
+	if !isSum(e) {
+		return e, false
+	}
+	terms := collectTerms(e, 1, nil)
+	for i := range terms {
+		u, sine, ok := trigSquare(terms[i].base)
+		if !ok || !sine {
+			continue
+		}
+		for j := range terms {
+			v, sine, ok := trigSquare(terms[j].base)
+			if ok && !sine && terms[i].coefficient == terms[j].coefficient && Equal(u, v) {
+				terms[i] = term{coefficient: terms[i].coefficient}
+				terms = append(terms[:j], terms[j+1:]...)
+				return sumOf(terms), true
+			}
+		}
+	}
+	return e, false
+}
+
+// negated returns -e if e is a negative number times something, like -x or -2*x
+func negated(e Expression) (Expression, bool) {
+	coefficient, base := splitCoefficient(e)
+	if coefficient >= 0 || math.IsNaN(coefficient) {
+		return e, false
+	}
+	return term{coefficient: -coefficient, base: base}.expression(), true
+}
+
+// negativeAngle moves the sign out of the argument of sin, cos and tan
+func negativeAngle(e Expression) (Expression, bool) {
+	switch v := e.(type) {
+	case Siner:
+		if u, ok := negated(v.A); ok {
+			return Negator{Siner{u}}, true
+		}
+	case Tanner:
+		if u, ok := negated(v.A); ok {
+			return Negator{Tanner{u}}, true
+		}
+	case Coser:
+		if u, ok := negated(v.A); ok {
+			return Coser{u}, true
+		}
+	}
+	return e, false
+}
+
+// tangent replaces sin(u)/cos(u) with tan(u)
+func tangent(e Expression) (Expression, bool) {
+	if d, ok := e.(Divider); ok {
+		s, sOk := d.A.(Siner)
+		c, cOk := d.B.(Coser)
+		if sOk && cOk && Equal(s.A, c.A) {
+			return Tanner{s.A}, true
+		}
+	}
+	return e, false
+}
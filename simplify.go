@@ -1,11 +1,17 @@
 package parser
 
-import "fmt"
+import (
+	"math"
+	"sort"
+)
 
 //Simplify simplifies a+b
-//Do something similar with add and subtract to what is done with multiplication
-//get list of a addends and subtractants and simplify that list down by combining like terms, then turn it back into tree
+//The terms of a chain of additions and subtractions are collected, like terms combined and the tree rebuilt in order, see simplifySum
 func (a Adder) Simplify() Expression {
+	return a.simplify(&rewriter{})
+}
+
+func (a Adder) simplify(rw *rewriter) Expression {
 	aIs0 := false
 	bIs0 := false
 	aIsConst := false
@@ -13,72 +19,233 @@ func (a Adder) Simplify() Expression {
 
 	aVal := 0.0
 	bVal := 0.0
-	A := a.A.Simplify()
-	B := a.B.Simplify()
-	switch v := A.(type) {
-	case Constant:
+	A := rw.simplify(a.A)
+	B := rw.simplify(a.B)
+	if v, ok := constantValue(A); ok {
 		aIsConst = true
-		aVal = v.Value
-		if v.Value == 0 {
+		aVal = v
+		if v == 0 {
 			aIs0 = true
 		}
 	}
-	switch v := B.(type) {
-	case Constant:
+	if v, ok := constantValue(B); ok {
 		bIsConst = true
-		bVal = v.Value
-		if v.Value == 0 {
+		bVal = v
+		if v == 0 {
 			bIs0 = true
 		}
 	}
 	if aIsConst && bIsConst {
-		return Constant{aVal + bVal}
+		return rw.rewrite("add constants", Adder{A, B}, Constant{aVal + bVal})
 	}
 	//Identity 0+0=0, 0+x=x
 	if aIs0 && bIs0 {
-		return Constant{0}
+		return rw.rewrite("add zero", Adder{A, B}, Constant{0})
 	} else if aIs0 {
-		return B
+		return rw.rewrite("add zero", Adder{A, B}, B)
 	} else if bIs0 {
-		return A
+		return rw.rewrite("add zero", Adder{A, B}, A)
 	}
 
-	return a
+	return simplifySum(rw, Adder{A, B})
 }
 
 //Simplify simplifies a-b
 func (s Subtractor) Simplify() Expression {
+	return s.simplify(&rewriter{})
+}
+
+func (s Subtractor) simplify(rw *rewriter) Expression {
 	aIs0 := false
 	bIs0 := false
-	A := s.A.Simplify()
-	B := s.B.Simplify()
-	switch v := A.(type) {
-	case Constant:
-		if v.Value == 0 {
-			aIs0 = true
+	A := rw.simplify(s.A)
+	B := rw.simplify(s.B)
+	aVal, aIsConst := constantValue(A)
+	bVal, bIsConst := constantValue(B)
+	aIs0 = aIsConst && aVal == 0
+	bIs0 = bIsConst && bVal == 0
+	if aIsConst && bIsConst {
+		return rw.rewrite("subtract constants", Subtractor{A, B}, Constant{aVal - bVal})
+	}
+	if aIs0 && bIs0 {
+		return rw.rewrite("subtract zero", Subtractor{A, B}, Constant{0})
+	} else if aIs0 {
+		//0-(-a) = a
+		if n, ok := B.(Negator); ok {
+			return rw.rewrite("subtract from zero", Subtractor{A, B}, n.A)
 		}
+		return rw.rewrite("subtract from zero", Subtractor{A, B}, Negator{B})
+	} else if bIs0 {
+		return rw.rewrite("subtract zero", Subtractor{A, B}, A)
+	}
+	return simplifySum(rw, Subtractor{A, B})
+}
+
+//term is coefficient*base. The base of a number is nil
+type term struct {
+	coefficient float64
+	base        Expression
+}
+
+//expression turns the term back into an expression
+func (t term) expression() Expression {
+	if t.base == nil {
+		return Constant{t.coefficient}
+	} else if t.coefficient == 1 {
+		return t.base
+	} else if t.coefficient == -1 {
+		return Negator{t.base}
+	}
+	return Multiplier{
+		A: Constant{t.coefficient},
+		B: t.base,
+	}
+}
+
+//collectTerms appends the terms of a chain of additions and subtractions to terms, multiplying them by sign
+//For a-(b+c) that is a, -b and -c
+func collectTerms(e Expression, sign float64, terms []term) []term {
+	switch v := e.(type) {
+	case Adder:
+		terms = collectTerms(v.A, sign, terms)
+		return collectTerms(v.B, sign, terms)
+	case Subtractor:
+		terms = collectTerms(v.A, sign, terms)
+		return collectTerms(v.B, -sign, terms)
+	case Negator:
+		return collectTerms(v.A, -sign, terms)
 	}
-	switch v := B.(type) {
+	coefficient, base := splitCoefficient(e)
+	return append(terms, term{coefficient: sign * coefficient, base: base})
+}
+
+//splitCoefficient splits the numbers multiplying e from the rest of it, so 3*(x*2) is 6 and x
+//The rest is nil if e is just a number
+func splitCoefficient(e Expression) (float64, Expression) {
+	switch v := e.(type) {
 	case Constant:
-		if v.Value == 0 {
-			bIs0 = true
+		return v.Value, nil
+	case Negator:
+		coefficient, base := splitCoefficient(v.A)
+		return -coefficient, base
+	case Multiplier:
+		if c, ok := v.A.(Constant); ok {
+			coefficient, base := splitCoefficient(v.B)
+			return c.Value * coefficient, base
+		}
+		if c, ok := v.B.(Constant); ok {
+			coefficient, base := splitCoefficient(v.A)
+			return c.Value * coefficient, base
 		}
 	}
-	if aIs0 && bIs0 {
-		return Constant{0}
-	} else if aIs0 {
-		return Multiplier{
-			A: Constant{-1},
-			B: B,
+	return 1, e
+}
+
+//simplifySum simplifies a sum or difference whose children are simplified. The numbers are added up and the coefficients
+//of like terms are combined, so 3*x+2*x is 5*x and x-x is 0. A named constant is kept if it is added once, like x+pi,
+//otherwise it is added to the numbers as multiplying it would fold it anyway
+//The terms are put in order of their string with the number last so the same terms always make the same tree, but led by a term that is added
+func simplifySum(rw *rewriter, sum Expression) Expression {
+	terms := collectTerms(sum, 1, nil)
+	total := 0.0
+	allConst := true
+	for _, t := range terms {
+		if t.base == nil {
+			total += t.coefficient
+		} else if v, ok := constantValue(t.base); ok {
+			total += t.coefficient * v
+		} else {
+			allConst = false
 		}
-	} else if bIs0 {
-		return A
 	}
-	return s
+	if allConst {
+		return rw.rewrite("add constants", sum, Constant{total})
+	}
+
+	//a*b and b*a are like terms
+	bases := newCommutativeExpressionIndex()
+	coefficients := []float64{}
+	number := 0.0
+	for _, t := range terms {
+		if t.base == nil {
+			number += t.coefficient
+			continue
+		}
+		i := bases.Add(t.base)
+		if i == len(coefficients) {
+			coefficients = append(coefficients, 0)
+		}
+		coefficients[i] += t.coefficient
+	}
+	collected := []term{}
+	keys := []string{}
+	for i, c := range coefficients {
+		if v, ok := constantValue(bases.At(i)); ok && c != 1 {
+			number += c * v
+		} else if c != 0 {
+			collected = append(collected, term{coefficient: c, base: bases.At(i)})
+			keys = append(keys, bases.At(i).String())
+		}
+	}
+	sort.Stable(termsByKey{collected, keys})
+	if number != 0 {
+		collected = append(collected, term{coefficient: number})
+	}
+	//Start with a term that is added if there is one so 9-x stays 9-x rather than -1*x+9
+	for i, t := range collected {
+		if t.coefficient > 0 {
+			copy(collected[1:i+1], collected[:i])
+			collected[0] = t
+			break
+		}
+	}
+
+	simplified := sumOf(collected)
+	if Equal(simplified, sum) {
+		return sum
+	}
+	if len(collected) < len(terms) {
+		return rw.rewrite("combine like terms", sum, simplified)
+	}
+	return rw.rewrite("order terms", sum, simplified)
+}
+
+//sumOf adds up terms in order, subtracting those with negative coefficients after the first
+func sumOf(terms []term) Expression {
+	var sum Expression = Constant{0}
+	for i, t := range terms {
+		if i == 0 {
+			sum = t.expression()
+		} else if t.coefficient < 0 {
+			t.coefficient = -t.coefficient
+			sum = Subtractor{A: sum, B: t.expression()}
+		} else {
+			sum = Adder{A: sum, B: t.expression()}
+		}
+	}
+	return sum
+}
+
+//termsByKey sorts terms by their keys
+type termsByKey struct {
+	terms []term
+	keys  []string
+}
+
+func (t termsByKey) Len() int           { return len(t.terms) }
+func (t termsByKey) Less(i, j int) bool { return t.keys[i] < t.keys[j] }
+func (t termsByKey) Swap(i, j int) {
+	t.terms[i], t.terms[j] = t.terms[j], t.terms[i]
+	t.keys[i], t.keys[j] = t.keys[j], t.keys[i]
 }
 
 //Simplify simplifies A*B
+//The factors of a chain of multiplications and divisions are collected and the powers of like factors combined, see simplifyProduct
 func (m Multiplier) Simplify() Expression {
+	return m.simplify(&rewriter{})
+}
+
+func (m Multiplier) simplify(rw *rewriter) Expression {
 	aIs0 := false
 	bIs0 := false
 	aIs1 := false
@@ -87,327 +254,254 @@ func (m Multiplier) Simplify() Expression {
 	bIsConst := false
 	aVal := 0.0
 	bVal := 0.0
-	//aSymbol := ""
-	//bSymbol := ""
 
-	fmt.Println("Simplifying multiplication{", m.String())
-	A := m.A.Simplify()
-	B := m.B.Simplify()
-	fmt.Println("Simplifying multiplication2}", Multiplier{A, B})
-
-	fmt.Printf("A: %v\n", A)
-	fmt.Printf("B: %v\n", B)
+	A := rw.simplify(m.A)
+	B := rw.simplify(m.B)
 	//Get data to check for identity rules (1*x=x, 0*x=0)
-	switch v := A.(type) {
-	case Constant:
+	if v, ok := constantValue(A); ok {
 		aIsConst = true
-		aVal = v.Value
-		aIs0 = v.Value == 0
-		aIs1 = v.Value == 1
-	case Variable:
-		//aSymbol = v.Symbol
+		aVal = v
+		aIs0 = v == 0
+		aIs1 = v == 1
 	}
-	switch v := B.(type) {
-	case Constant:
+	if v, ok := constantValue(B); ok {
 		bIsConst = true
-		bVal = v.Value
-		bIs0 = v.Value == 0
-		bIs1 = v.Value == 1
-	case Variable:
-		//bSymbol = v.Symbol
+		bVal = v
+		bIs0 = v == 0
+		bIs1 = v == 1
 	}
 
 	//Check Identity rules
 	if aIs0 || bIs0 {
-		fmt.Println("ZEROS", m.String())
-		return Constant{0}
+		return rw.rewrite("multiply by zero", Multiplier{A, B}, Constant{0})
 	} else if aIs1 {
-		return B
+		return rw.rewrite("multiply by one", Multiplier{A, B}, B)
 	} else if bIs1 {
-		return A
+		return rw.rewrite("multiply by one", Multiplier{A, B}, A)
 	}
 	//Other possibillities
 	if aIsConst && bIsConst {
-		return Constant{aVal * bVal}
-	} else if aIsConst {
-		return Multiplier{
-			Constant{aVal},
-			B,
-		}
-
-	} else if bIsConst {
-		return Multiplier{
-			A: A,
-			B: Constant{bVal},
-		}
-	}
-
-	////Simplify x*x to x^2
-	//if aSymbol == bSymbol && aSymbol != "" {
-	//	return Powerer{
-	//		Base:     Variable{aSymbol},
-	//		Exponent: Constant{2},
-	//	}
-	//}
-	//while If Mul*Div or Mul*mul or Mul*div
-	//list of numerators, list of denominators
-	//Variable and degree, if its just Variable{x} turn it into x^1 so later can subtract
-
-	//CurrentElement := m
-	//Numerator, Denominator := ListMuls(CurrentElement, true)
-	//fmt.Printf("Numerator: %v\n", Numerator)
-	//fmt.Printf("Denominator: %v\n", Denominator)
-	////Turn them into powers
-	//simplified := SimplifyFraction(Numerator, Denominator)
-	//
-	//return simplified
-	//for {
-	//	switch type of Current element a,b
-	//	if is mul or div, follow that branch
-	//	if not, add to numerator or denominator, break out of that branch
-	//
-	//}
-
-	fmt.Println("Never Simplified")
-	return Multiplier{
-		A: A,
-		B: B,
+		return rw.rewrite("multiply constants", Multiplier{A, B}, Constant{aVal * bVal})
 	}
+	return simplifyProduct(rw, Multiplier{A, B})
 }
 
-//ListMuls lists all the multiples in the numerator and denominator in the expression
-//Fix Division
-//if !Num2Num, in divisions, things that say they go in num go to denom and vice versa
-//Call next level with things reversed
-//tmrw implement outer case divider such that it puts a in num and b in denom and also sorts it out with its children
+//ListMuls lists the factors in the numerator and denominator of a chain of multiplications and divisions
+//For a*b/(c/d) that is a, b and d over c. If NumToNum is false the numerator and denominator are swapped
+//A negation is listed as a factor of -1
 func ListMuls(e Expression, NumToNum bool) ([]Expression, []Expression) {
 	num := []Expression{}
 	denom := []Expression{}
 	switch v := e.(type) {
 	case Multiplier:
-		fmt.Println("Multiplication count muls")
 		n2, d2 := ListMuls(v.A, NumToNum)
 		n3, d3 := ListMuls(v.B, NumToNum)
-		if NumToNum {
-			num = append(num, n2...)
-			denom = append(denom, d2...)
-			num = append(num, n3...)
-			denom = append(denom, d3...)
-
-		} else {
-			num = append(num, d2...)
-			denom = append(denom, n2...)
-			num = append(num, d3...)
-			denom = append(denom, n3...)
-
-		}
-
+		num = append(append(num, n2...), n3...)
+		denom = append(append(denom, d2...), d3...)
 	case Divider:
-		fmt.Println("DIVIDERERER")
 		n2, d2 := ListMuls(v.A, NumToNum)
-
-		n3, d3 := ListMuls(v.B, NumToNum)
+		//The denominator of the denominator is in the numerator
+		n3, d3 := ListMuls(v.B, !NumToNum)
+		num = append(append(num, n2...), n3...)
+		denom = append(append(denom, d2...), d3...)
+	case Negator:
+		n2, d2 := ListMuls(v.A, NumToNum)
+		num = append(append(num, Constant{-1}), n2...)
+		denom = append(denom, d2...)
+	default:
 		if NumToNum {
-			num = append(num, n2...)
-			denom = append(denom, d2...)
-			num = append(num, d3...)
-			denom = append(denom, n3...)
+			num = append(num, v)
 		} else {
-			num = append(num, d2...)
-			denom = append(denom, n2...)
-			num = append(num, n3...)
-			denom = append(denom, d3...)
-
+			denom = append(denom, v)
 		}
-		fmt.Println("num============================================", num)
-		fmt.Println("denom============================================", denom)
-
-	default:
-		num = append(num, v)
 	}
-
 	return num, denom
 }
 
 //Simplify simplifies a/b
 func (d Divider) Simplify() Expression {
+	return d.simplify(&rewriter{})
+}
+
+func (d Divider) simplify(rw *rewriter) Expression {
 	bIs1 := false
 	aIs0 := false
 	aVal := 0.0
 	bVal := 0.0
 	aIsConst := false
 	bIsConst := false
-	A := d.A.Simplify()
-	B := d.B.Simplify()
-	fmt.Println("Simplifying devision of ", A, "/", B)
-	switch v := A.(type) {
-	case Constant:
+	A := rw.simplify(d.A)
+	B := rw.simplify(d.B)
+	if v, ok := constantValue(A); ok {
 		aIsConst = true
-		aVal = v.Value
-		aIs0 = v.Value == 0
+		aVal = v
+		aIs0 = v == 0
 	}
-	switch v := B.(type) {
-	case Constant:
+	if v, ok := constantValue(B); ok {
 		bIsConst = true
-		bVal = v.Value
-		bIs1 = v.Value == 0
+		bVal = v
+		bIs1 = v == 1
 	}
 	//Identities
 	if bIs1 {
-		return A
+		return rw.rewrite("divide by one", Divider{A, B}, A)
 	} else if aIs0 {
-		return Constant{0}
+		return rw.rewrite("divide zero", Divider{A, B}, Constant{0})
 	} else if aIsConst && bIsConst {
 		//const over const simplifies to const
-		return Constant{aVal / bVal}
-	}
-	////Get list of numerator and denominator
-	//Numerator, Denominator := ListMuls(d, true)
-	////Numerator2, Denominator2 := ListMuls(B, false)
-	////Numerator = append(Numerator, Numerator2...)
-	////Denominator = append(Denominator, Denominator2...)
-	//fmt.Println("Divider num", Numerator)
-	//fmt.Println("Divider denom", Denominator)
-	//fmt.Printf("For dividing num: %v, denom: %v\n", Numerator, Denominator)
-	//
-	//simplified := SimplifyFraction(Numerator, Denominator)
-	//return simplified
-	////Didn't simplify
-	return Divider{
-		A: A,
-		B: B,
+		return rw.rewrite("divide constants", Divider{A, B}, Constant{aVal / bVal})
 	}
+	return simplifyProduct(rw, Divider{A, B})
 }
 
-//SimplifyFraction simplifies a fraction
-func SimplifyFraction(Numerator, Denominator []Expression) Expression {
-	degreeCounts := map[Expression][]Expression{}
-	coefficientsInNum := []float64{}
-	coefficientsInDenom := []float64{}
+//simplifyProduct simplifies a product or quotient whose children are simplified with SimplifyFraction
+func simplifyProduct(rw *rewriter, product Expression) Expression {
+	num, denom := ListMuls(product, true)
+	simplified := SimplifyFraction(num, denom)
+	if Equal(simplified, product) {
+		return product
+	}
+	if n, d := ListMuls(simplified, true); len(n)+len(d) < len(num)+len(denom) {
+		return rw.rewrite("combine like factors", product, simplified)
+	}
+	return rw.rewrite("order factors", product, simplified)
+}
 
-	//simplifiedDenom := []Expression{}
-	fmt.Printf("Numerator: %v\n", Numerator)
+//SimplifyFraction multiplies the factors in Numerator and divides by the factors in Denominator
+//The numbers are multiplied together and put first. The powers a factor is raised to are added up, so x*x is x^2
+//and x^3/x is x^2, and factors raised to the power of 0 cancel out. The factors are kept in the order they first appear
+//and those left raised to a negative number go in the denominator
+func SimplifyFraction(Numerator, Denominator []Expression) Expression {
+	//Each different base and the degrees it is raised to
+	bases := newExpressionIndex()
+	degreeCounts := [][]Expression{}
+	addDegree := func(base, degree Expression) {
+		i := bases.Add(base)
+		if i == len(degreeCounts) {
+			degreeCounts = append(degreeCounts, nil)
+		}
+		degreeCounts[i] = append(degreeCounts[i], degree)
+	}
+	coefficient := 1.0
 
 	for _, e := range Numerator {
 		switch v := e.(type) {
 		case Powerer:
-			//if is var to power, add power to degreecounts,
-			//if is something else to power, use its string representation as key and add degree
-
-			degreeCounts[v.Base] = append(degreeCounts[v.Base], v.Exponent)
-		case Variable:
-			degreeCounts[v] = append(degreeCounts[v], Constant{1})
+			addDegree(v.Base, v.Exponent)
 		case Constant:
-			coefficientsInNum = append(coefficientsInNum, v.Value)
+			coefficient *= v.Value
 		default:
-			degreeCounts[v] = append(degreeCounts[v], Constant{1})
-			fmt.Println("Counting degree of ", v.String())
-
+			addDegree(v, Constant{1})
 		}
 	}
 	for _, e := range Denominator {
 		switch v := e.(type) {
 		case Powerer:
-			//if is var to power, subtract power to degreecounts,
-			degreeCounts[v] = append(degreeCounts[v], Multiplier{A: Constant{-1}, B: v.Exponent})
-		case Variable:
-			fmt.Println("Variavle in denom")
-			degreeCounts[v] = append(degreeCounts[v], Constant{-1})
+			addDegree(v.Base, Multiplier{A: Constant{-1}, B: v.Exponent})
 		case Constant:
-			coefficientsInDenom = append(coefficientsInDenom, v.Value)
+			coefficient /= v.Value
 		default:
-			//simplifiedNum = append(simplifiedNum, v)
-			degreeCounts[v] = append(degreeCounts[v], Constant{-1})
-			fmt.Println("Counting degree of ", v.String())
-
+			addDegree(v, Constant{-1})
 		}
 	}
-
-	//Find the product of all the coeffecients
-	CoeffecientProduct := 1.0
-	for i := range coefficientsInNum {
-		CoeffecientProduct *= coefficientsInNum[i]
-	}
-	for i := range coefficientsInDenom {
-		CoeffecientProduct /= coefficientsInDenom[i]
+	if coefficient == 0 {
+		return Constant{0}
 	}
 
-	fmt.Printf("degreeCounts: %v\n", degreeCounts)
-	fmt.Printf("prod: %v\n", CoeffecientProduct)
-
-	parts := []Expression{}
-	for k, v := range degreeCounts {
-		if len(v) == 1 {
-			parts = append(parts, k.Simplify())
-			continue
-		} else if len(v) == 2 {
-			parts = append(parts, Powerer{Base: k, Exponent: Adder{
-				A: v[0],
-				B: v[1],
-			}}.Simplify())
-		} else {
-			degree := Adder{
-				A: v[0],
-				B: v[1],
-			}
-			for i := 2; i < len(v); i++ {
-				degree = Adder{
-					A: degree,
-					B: v[i],
-				}
+	numParts := []Expression{}
+	denomParts := []Expression{}
+	for i, v := range degreeCounts {
+		base := bases.At(i)
+		degree := v[0]
+		for _, d := range v[1:] {
+			degree = Adder{
+				A: degree,
+				B: d,
 			}
-
-			parts = append(parts, Powerer{
-				Base:     k,
-				Exponent: degree,
-			}.Simplify())
 		}
+		degree = degree.Simplify()
+		d, ok := constantValue(degree)
+		switch {
+		case ok && d == 0:
+			//x/x is 1
+		case ok && d == 1:
+			numParts = append(numParts, base)
+		case ok && d == -1:
+			denomParts = append(denomParts, base)
+		case ok && d < 0:
+			denomParts = append(denomParts, Powerer{Base: base, Exponent: Constant{-d}})
+		default:
+			numParts = append(numParts, Powerer{Base: base, Exponent: degree})
+		}
+	}
+
+	//x/2 stays x/2 rather than 0.5*x
+	denomCoefficient := 1.0
+	if r := 1 / coefficient; coefficient != math.Trunc(coefficient) && r == math.Trunc(r) && !math.IsInf(r, 0) {
+		coefficient, denomCoefficient = 1, r
+	}
+	num := multiplyParts(coefficient, numParts)
+	if len(denomParts) == 0 && denomCoefficient == 1 {
+		return num
+	}
+	return Divider{
+		A: num,
+		B: multiplyParts(denomCoefficient, denomParts),
 	}
-	fmt.Printf("parts: %v\n", parts)
+}
+
+//multiplyParts returns coefficient*(parts[0]*parts[1]*...), leaving out the coefficient if it is 1 and negating if it is -1
+func multiplyParts(coefficient float64, parts []Expression) Expression {
 	if len(parts) == 0 {
-		return Constant{CoeffecientProduct}
-	} else if len(parts) == 1 {
-		return Multiplier{
-			A: Constant{CoeffecientProduct},
-			B: parts[0],
-		}.Simplify()
-	} else {
-		prevProduct := Multiplier{
-			A: parts[0],
-			B: parts[1],
-		}
-		for i := 2; i < len(parts); i++ {
-			prevProduct = Multiplier{
-				A: prevProduct,
-				B: parts[i],
-			}
+		return Constant{coefficient}
+	}
+	product := parts[0]
+	for _, part := range parts[1:] {
+		product = Multiplier{
+			A: product,
+			B: part,
 		}
-		return Multiplier{
-			A: Constant{CoeffecientProduct},
-			B: prevProduct,
-		} //.Simplify()
+	}
+	if coefficient == 1 {
+		return product
+	} else if coefficient == -1 {
+		return Negator{product}
+	}
+	return Multiplier{
+		A: Constant{coefficient},
+		B: product,
 	}
 }
 
 //Simplify simplifies a powerer
 func (p Powerer) Simplify() Expression {
+	return p.simplify(&rewriter{})
+}
+
+func (p Powerer) simplify(rw *rewriter) Expression {
 	One := Constant{1}
 	Zero := Constant{0}
 
-	if p.Exponent.Simplify() == One {
-		return p.Base
-	} else if p.Exponent.Simplify() == Zero {
-		return Constant{1}
+	Base := rw.simplify(p.Base)
+	Exponent := rw.simplify(p.Exponent)
+	if Equal(Exponent, One) {
+		return rw.rewrite("power of one", Powerer{Base, Exponent}, Base)
+	} else if Equal(Exponent, Zero) {
+		return rw.rewrite("power of zero", Powerer{Base, Exponent}, Constant{1})
 	}
 	return Powerer{
-		Base:     p.Base.Simplify(),
-		Exponent: p.Exponent.Simplify(),
+		Base:     Base,
+		Exponent: Exponent,
 	}
 }
 
 //Simplify simplifies ln(a)
 func (n NaturalLogger) Simplify() Expression {
-	return NaturalLogger{n.A.Simplify()}
+	return n.simplify(&rewriter{})
+}
+
+func (n NaturalLogger) simplify(rw *rewriter) Expression {
+	return NaturalLogger{rw.simplify(n.A)}
 }
 
 //Simplify simplifies a constant. can't really simplify it at all
@@ -422,10 +516,223 @@ func (v Variable) Simplify() Expression {
 
 //Simplify simplifies cos(a)
 func (c Coser) Simplify() Expression {
-	return Coser{c.A.Simplify()}
+	return c.simplify(&rewriter{})
+}
+
+func (c Coser) simplify(rw *rewriter) Expression {
+	return Coser{rw.simplify(c.A)}
 }
 
 //Simplify simplifies sin(a)
 func (s Siner) Simplify() Expression {
-	return Siner{s.A.Simplify()}
+	return s.simplify(&rewriter{})
+}
+
+func (s Siner) simplify(rw *rewriter) Expression {
+	return Siner{rw.simplify(s.A)}
+}
+
+//Simplify simplifies -a
+func (n Negator) Simplify() Expression {
+	return n.simplify(&rewriter{})
+}
+
+func (n Negator) simplify(rw *rewriter) Expression {
+	A := rw.simplify(n.A)
+	switch v := A.(type) {
+	case Constant:
+		return rw.rewrite("negate constant", Negator{A}, Constant{-v.Value})
+	case Negator:
+		//--a = a
+		return rw.rewrite("double negation", Negator{A}, v.A)
+	}
+	return Negator{A}
+}
+
+//Simplify simplifies the arguments of f(a, b, ...) and evaluates it if they are all constant
+func (f FunctionCall) Simplify() Expression {
+	return f.simplify(&rewriter{})
+}
+
+func (f FunctionCall) simplify(rw *rewriter) Expression {
+	args := make([]Expression, len(f.Args))
+	values := make([]float64, len(f.Args))
+	allConst := true
+	for i := range f.Args {
+		args[i] = rw.simplify(f.Args[i])
+		if v, ok := constantValue(args[i]); ok {
+			values[i] = v
+		} else {
+			allConst = false
+		}
+	}
+	simplified := FunctionCall{
+		Func: f.Func,
+		Args: args,
+	}
+	if allConst {
+		return rw.rewrite("evaluate constant function", simplified, Constant{f.Func.Eval(values)})
+	}
+	return simplified
+}
+
+//Simplify simplifies tan(a)
+func (t Tanner) Simplify() Expression {
+	return t.simplify(&rewriter{})
+}
+
+func (t Tanner) simplify(rw *rewriter) Expression {
+	A := rw.simplify(t.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", Tanner{A}, Constant{math.Tan(v)})
+	}
+	return Tanner{A}
+}
+
+//Simplify simplifies exp(a)
+func (e Exponentiator) Simplify() Expression {
+	return e.simplify(&rewriter{})
+}
+
+func (e Exponentiator) simplify(rw *rewriter) Expression {
+	A := rw.simplify(e.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", Exponentiator{A}, Constant{math.Exp(v)})
+	}
+	return Exponentiator{A}
+}
+
+//Simplify simplifies sqrt(a)
+func (s SquareRooter) Simplify() Expression {
+	return s.simplify(&rewriter{})
+}
+
+func (s SquareRooter) simplify(rw *rewriter) Expression {
+	A := rw.simplify(s.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", SquareRooter{A}, Constant{math.Sqrt(v)})
+	}
+	return SquareRooter{A}
+}
+
+//Simplify simplifies abs(a)
+func (a AbsoluteValuer) Simplify() Expression {
+	return a.simplify(&rewriter{})
+}
+
+func (a AbsoluteValuer) simplify(rw *rewriter) Expression {
+	A := rw.simplify(a.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", AbsoluteValuer{A}, Constant{math.Abs(v)})
+	}
+	return AbsoluteValuer{A}
+}
+
+//Simplify simplifies log10(a)
+func (c CommonLogger) Simplify() Expression {
+	return c.simplify(&rewriter{})
+}
+
+func (c CommonLogger) simplify(rw *rewriter) Expression {
+	A := rw.simplify(c.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", CommonLogger{A}, Constant{math.Log10(v)})
+	}
+	return CommonLogger{A}
+}
+
+//Simplify simplifies log2(a)
+func (b BinaryLogger) Simplify() Expression {
+	return b.simplify(&rewriter{})
+}
+
+func (b BinaryLogger) simplify(rw *rewriter) Expression {
+	A := rw.simplify(b.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", BinaryLogger{A}, Constant{math.Log2(v)})
+	}
+	return BinaryLogger{A}
+}
+
+//Simplify simplifies asin(a)
+func (a ArcSiner) Simplify() Expression {
+	return a.simplify(&rewriter{})
+}
+
+func (a ArcSiner) simplify(rw *rewriter) Expression {
+	A := rw.simplify(a.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", ArcSiner{A}, Constant{math.Asin(v)})
+	}
+	return ArcSiner{A}
+}
+
+//Simplify simplifies acos(a)
+func (a ArcCoser) Simplify() Expression {
+	return a.simplify(&rewriter{})
+}
+
+func (a ArcCoser) simplify(rw *rewriter) Expression {
+	A := rw.simplify(a.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", ArcCoser{A}, Constant{math.Acos(v)})
+	}
+	return ArcCoser{A}
+}
+
+//Simplify simplifies atan(a)
+func (a ArcTanner) Simplify() Expression {
+	return a.simplify(&rewriter{})
+}
+
+func (a ArcTanner) simplify(rw *rewriter) Expression {
+	A := rw.simplify(a.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", ArcTanner{A}, Constant{math.Atan(v)})
+	}
+	return ArcTanner{A}
+}
+
+//Simplify simplifies sinh(a)
+func (h HyperbolicSiner) Simplify() Expression {
+	return h.simplify(&rewriter{})
+}
+
+func (h HyperbolicSiner) simplify(rw *rewriter) Expression {
+	A := rw.simplify(h.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", HyperbolicSiner{A}, Constant{math.Sinh(v)})
+	}
+	return HyperbolicSiner{A}
+}
+
+//Simplify simplifies cosh(a)
+func (h HyperbolicCoser) Simplify() Expression {
+	return h.simplify(&rewriter{})
+}
+
+func (h HyperbolicCoser) simplify(rw *rewriter) Expression {
+	A := rw.simplify(h.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", HyperbolicCoser{A}, Constant{math.Cosh(v)})
+	}
+	return HyperbolicCoser{A}
+}
+
+//Simplify simplifies tanh(a)
+func (h HyperbolicTanner) Simplify() Expression {
+	return h.simplify(&rewriter{})
+}
+
+func (h HyperbolicTanner) simplify(rw *rewriter) Expression {
+	A := rw.simplify(h.A)
+	if v, ok := constantValue(A); ok {
+		return rw.rewrite("evaluate constant function", HyperbolicTanner{A}, Constant{math.Tanh(v)})
+	}
+	return HyperbolicTanner{A}
+}
+
+//Simplify simplifies a named constant. It is kept by name so it still prints as pi rather than 3.14159...
+func (n NamedConstant) Simplify() Expression {
+	return n
 }
@@ -0,0 +1,88 @@
+package parser
+
+// Tracer is told about every rewrite made while simplifying an expression
+type Tracer interface {
+	// Rewrite is called with the name of the rule that was applied, the expression it was applied to and what it became
+	Rewrite(rule string, before, after Expression)
+}
+
+// TracerFunc lets an ordinary function be used as a Tracer
+type TracerFunc func(rule string, before, after Expression)
+
+// Rewrite calls f(rule, before, after)
+func (f TracerFunc) Rewrite(rule string, before, after Expression) {
+	f(rule, before, after)
+}
+
+// WithTracer has every rewrite reported to t
+func WithTracer(t Tracer) SimplifyOption {
+	return func(s *Simplifier) {
+		s.tracer = t
+	}
+}
+
+// SimplifyWith simplifies e with a Simplifier made with opts. With no rule sets given that is like e.Simplify()
+// repeated until e stops changing
+// Nothing is printed; pass WithTracer to see each rewrite that is made
+func SimplifyWith(e Expression, opts ...SimplifyOption) Expression {
+	return NewSimplifier(opts...).Simplify(e)
+}
+
+// rewriter holds what is needed during one simplification
+type rewriter struct {
+	tracer Tracer
+	// local is set when the children of expressions are already simplified so only the expression itself is rewritten
+	local bool
+}
+
+// rewritable is implemented by the expressions that report their rewrites while simplifying
+type rewritable interface {
+	simplify(rw *rewriter) Expression
+}
+
+// simplify simplifies e, falling back to e.Simplify() for expressions defined outside the package
+func (rw *rewriter) simplify(e Expression) Expression {
+	if rw.local {
+		return e
+	}
+	if r, ok := e.(rewritable); ok {
+		return r.simplify(rw)
+	}
+	return e.Simplify()
+}
+
+// rewrite reports that rule turned before into after and returns after
+func (rw *rewriter) rewrite(rule string, before, after Expression) Expression {
+	if rw.tracer != nil {
+		rw.tracer.Rewrite(rule, before, after)
+	}
+	return after
+}
+
+// Step is one rewrite made while simplifying
+type Step struct {
+	// Rule is the name of the rule that was applied, such as "multiply by one"
+	Rule string
+	// Before is the expression the rule was applied to and After is what it became
+	Before, After Expression
+}
+
+// String returns the step as before → after
+func (s Step) String() string {
+	return s.Before.String() + " → " + s.After.String()
+}
+
+// Latex returns the step as before \rightarrow after in latex
+func (s Step) Latex() string {
+	return s.Before.Latex() + ` \rightarrow ` + s.After.Latex()
+}
+
+// SimplifyWithSteps simplifies e like SimplifyWith and returns every rewrite made on the way, in the order they were made
+// Subexpressions are simplified before the expressions containing them
+func SimplifyWithSteps(e Expression, opts ...SimplifyOption) (Expression, []Step) {
+	var steps []Step
+	opts = append(opts, WithTracer(TracerFunc(func(rule string, before, after Expression) {
+		steps = append(steps, Step{Rule: rule, Before: before, After: after})
+	})))
+	return SimplifyWith(e, opts...), steps
+}
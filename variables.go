@@ -0,0 +1,78 @@
+package parser
+
+import "sort"
+
+//Variables returns the names of all the variables in e in sorted order
+func Variables(e Expression) []string {
+	set := FreeVariables(e)
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//FreeVariables returns the set of variables that e depends on
+//There is nothing that binds a variable inside an expression so this is every variable in it
+func FreeVariables(e Expression) map[string]bool {
+	set := map[string]bool{}
+	collectVariables(e, set)
+	return set
+}
+
+//collectVariables adds the symbol of every variable in e to set
+func collectVariables(e Expression, set map[string]bool) {
+	Walk(e, func(e Expression) bool {
+		if v, ok := e.(Variable); ok {
+			set[v.Symbol] = true
+		}
+		return true
+	})
+}
+
+//Substitute replaces every variable in e that has a binding with the expression it is bound to
+//All replacements happen at once so variables inside the bound expressions are not replaced themselves
+//x = r*cos(t) is Substitute(e, map[string]Expression{"x": Multiplier{Variable{"r"}, Coser{Variable{"t"}}}})
+func Substitute(e Expression, bindings map[string]Expression) Expression {
+	return Rewrite(e, func(e Expression) Expression {
+		if v, ok := e.(Variable); ok {
+			if b, ok := bindings[v.Symbol]; ok {
+				return b
+			}
+		}
+		return e
+	})
+}
+
+//PartialEvaluate replaces the variables in values with constants and simplifies the result
+//Variables without a value are left in the expression
+func PartialEvaluate(e Expression, values map[string]float64) Expression {
+	bindings := make(map[string]Expression, len(values))
+	for name, value := range values {
+		bindings[name] = Constant{value}
+	}
+	return Substitute(e, bindings).Simplify()
+}
+
+//EvaluateStrict evaluates e like Evaluate but returns an UnboundVariableError instead of using 0 for missing variables
+func EvaluateStrict(e Expression, vars map[string]float64) (float64, error) {
+	if err := checkBound(Variables(e), vars); err != nil {
+		return 0, err
+	}
+	return e.Evaluate(vars), nil
+}
+
+//checkBound returns an UnboundVariableError listing the names that are not in vars
+func checkBound(names []string, vars map[string]float64) error {
+	var missing []string
+	for _, name := range names {
+		if _, ok := vars[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return &UnboundVariableError{Symbols: missing}
+	}
+	return nil
+}